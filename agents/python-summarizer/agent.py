@@ -8,7 +8,9 @@ Uses manual protobuf wire format encoding so the only dependency is grpcio.
 """
 
 import re
+import signal
 import sys
+import threading
 from concurrent import futures
 
 import grpc
@@ -116,6 +118,21 @@ class TaskEvent:
         )
 
 
+class CancelRequest:
+    __slots__ = ("task_id",)
+
+    def __init__(self, task_id=""):
+        self.task_id = task_id
+
+    @classmethod
+    def from_bytes(cls, data):
+        fields = _decode_fields(data)
+        req = cls()
+        for v in fields.get(1, []):
+            req.task_id = v.decode("utf-8") if isinstance(v, bytes) else str(v)
+        return req
+
+
 class HealthResponse:
     __slots__ = ("status", "agent_name")
 
@@ -140,6 +157,10 @@ def _split_sentences(text):
 
 
 class AgentServicer:
+    def __init__(self):
+        self._lock = threading.Lock()
+        self._cancelled = {}  # task_id -> threading.Event, for running tasks
+
     def Execute(self, request_bytes, context):
         req = TaskRequest.from_bytes(request_bytes)
 
@@ -149,6 +170,16 @@ class AgentServicer:
             ).to_bytes()
             return
 
+        cancelled = threading.Event()
+        with self._lock:
+            self._cancelled[req.task_id] = cancelled
+        try:
+            yield from self._summarize(req, cancelled)
+        finally:
+            with self._lock:
+                self._cancelled.pop(req.task_id, None)
+
+    def _summarize(self, req, cancelled):
         sentences = _split_sentences(req.input)
         n = min(3, len(sentences))
         summary = " ".join(sentences[:n])
@@ -161,6 +192,12 @@ class AgentServicer:
             payload=f"Extracted {n} sentences from {len(sentences)} total",
         ).to_bytes()
 
+        if cancelled.is_set():
+            yield TaskEvent(
+                task_id=req.task_id, type_="cancelled", payload="cancelled"
+            ).to_bytes()
+            return
+
         yield TaskEvent(
             task_id=req.task_id, type_="result", payload=summary
         ).to_bytes()
@@ -168,6 +205,15 @@ class AgentServicer:
     def Health(self, request_bytes, context):
         return HealthResponse(status="ok", agent_name="python-summarizer").to_bytes()
 
+    def Cancel(self, request_bytes, context):
+        req = CancelRequest.from_bytes(request_bytes)
+        with self._lock:
+            cancelled = self._cancelled.get(req.task_id)
+        if cancelled is None:
+            context.abort(grpc.StatusCode.NOT_FOUND, f"task {req.task_id} is not running")
+        cancelled.set()
+        return b""
+
 
 # ---------------------------------------------------------------------------
 # gRPC server
@@ -192,6 +238,11 @@ class _Handler(grpc.GenericRpcHandler):
                 request_deserializer=_identity,
                 response_serializer=_identity,
             ),
+            "/agent.AgentService/Cancel": grpc.unary_unary_rpc_method_handler(
+                servicer.Cancel,
+                request_deserializer=_identity,
+                response_serializer=_identity,
+            ),
         }
 
     def service(self, handler_call_details):
@@ -208,6 +259,9 @@ def serve():
     # Handshake: tell the orchestrator which port we're on
     print(f"AGENT_PORT={port}", flush=True)
 
+    # The orchestrator sends SIGTERM on stop; finish in-flight RPCs first
+    signal.signal(signal.SIGTERM, lambda *_: server.stop(grace=5))
+
     try:
         server.wait_for_termination()
     except KeyboardInterrupt:
@@ -70,9 +70,16 @@ function analyzeSentiment(text) {
 // gRPC service implementation
 // ---------------------------------------------------------------------------
 
+// Calls of running tasks by task ID, so Cancel can end them.
+const running = new Map();
+
 function execute(call) {
   const req = call.request;
   const taskId = req.taskId || req.task_id || "";
+  running.set(taskId, call);
+  call.on("finish", () => running.delete(taskId));
+  // The orchestrator dropped the stream (client went away)
+  call.on("cancelled", () => running.delete(taskId));
   const skill = req.skill || "";
   const input = req.input || "";
 
@@ -109,6 +116,19 @@ function health(call, callback) {
   callback(null, { status: "ok", agentName: "ts-sentiment" });
 }
 
+function cancel(call, callback) {
+  const taskId = call.request.taskId || call.request.task_id || "";
+  const target = running.get(taskId);
+  if (!target) {
+    callback({ code: grpc.status.NOT_FOUND, details: `task ${taskId} is not running` });
+    return;
+  }
+  running.delete(taskId);
+  target.write({ taskId: taskId, type: "cancelled", payload: "cancelled" });
+  target.end();
+  callback(null, {});
+}
+
 // ---------------------------------------------------------------------------
 // Server startup
 // ---------------------------------------------------------------------------
@@ -119,6 +139,7 @@ function main() {
   server.addService(agentProto.AgentService.service, {
     Execute: execute,
     Health: health,
+    Cancel: cancel,
   });
 
   server.bindAsync(
@@ -134,6 +155,11 @@ function main() {
       console.log(`AGENT_PORT=${port}`);
     }
   );
+
+  // The orchestrator sends SIGTERM on stop; finish in-flight RPCs first
+  process.on("SIGTERM", () => {
+    server.tryShutdown(() => process.exit(0));
+  });
 }
 
 main();
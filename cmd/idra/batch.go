@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"idra/internal/batch"
+)
+
+// batchCmd runs "idra batch run": it uploads a JSONL file to the running
+// server, reports progress on stderr and writes the results, in input
+// order, to stdout or -o.
+func batchCmd(args []string) error {
+	if len(args) == 0 || args[0] != "run" {
+		return fmt.Errorf("usage: idra batch run [flags] <file.jsonl>")
+	}
+	fs := flag.NewFlagSet("batch run", flag.ExitOnError)
+	concurrency := fs.Int("concurrency", 4, "tasks to run at once")
+	skill := fs.String("skill", "", "skill for lines that don't name one")
+	timeout := fs.Duration("timeout", 0, "timeout per task")
+	out := fs.String("o", "", "write results to this file instead of stdout")
+	addr := fs.String("addr", "", "address of the idra server (default: the port in config.json)")
+	fs.Parse(args[1:])
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: idra batch run [flags] <file.jsonl>")
+	}
+
+	c, err := newAPIClient(*addr)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	q := url.Values{"concurrency": {strconv.Itoa(*concurrency)}}
+	if *skill != "" {
+		q.Set("skill", *skill)
+	}
+	if *timeout > 0 {
+		q.Set("timeout", timeout.String())
+	}
+	resp, err := c.request(http.MethodPost, "/batches?"+q.Encode(), "application/x-ndjson", f)
+	if err != nil {
+		return err
+	}
+	var b batch.Batch
+	err = json.NewDecoder(resp.Body).Decode(&b)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "batch %s: %d lines\n", b.ID, b.Total)
+
+	// Ctrl+C cancels the batch on the server; the lines that finished are
+	// still written out.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	interrupted := ctx.Done()
+	for b.FinishedAt == nil {
+		select {
+		case <-tick.C:
+		case <-interrupted:
+			interrupted = nil
+			if err := c.do(http.MethodDelete, "/batches/"+b.ID, nil, nil); err != nil {
+				return err
+			}
+		}
+		if err := c.do(http.MethodGet, "/batches/"+b.ID, nil, &b); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "\r%d/%d done, %d failed", b.Done, b.Total, b.Failed)
+	}
+	fmt.Fprintf(os.Stderr, "\nbatch %s %s\n", b.ID, b.State)
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		file, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	resp, err = c.request(http.MethodGet, "/batches/"+b.ID+"/results", "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return err
+	}
+	if b.State != batch.StateCompleted || b.Failed > 0 {
+		return fmt.Errorf("%d of %d lines did not succeed", b.Total-b.Succeeded, b.Total)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"idra/internal/config"
+)
+
+// apiClient calls the REST API of the local server.
+type apiClient struct {
+	base  string
+	token string
+}
+
+// newAPIClient returns a client for the server at addr, or at the port in
+// config.json if addr is empty, authenticated with the admin bearer token.
+func newAPIClient(addr string) (*apiClient, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	if addr == "" {
+		addr = fmt.Sprintf("127.0.0.1:%d", cfg.Port)
+	}
+	return &apiClient{base: "http://" + addr + "/api/v1", token: cfg.BearerToken}, nil
+}
+
+// request sends a request with a body of the given content type, if any,
+// and fails on a non-2xx response with the server's error message.
+func (c *apiClient) request(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("is idra running? %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) != nil || e.Error == "" {
+			e.Error = resp.Status
+		}
+		return nil, fmt.Errorf("%s %s: %s", method, path, e.Error)
+	}
+	return resp, nil
+}
+
+// do sends in, if not nil, as JSON and decodes the JSON response into out,
+// if not nil.
+func (c *apiClient) do(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	resp, err := c.request(method, path, "application/json", body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -42,6 +42,16 @@ func main() {
 			os.Exit(1)
 		}
 		serviceCmd(os.Args[2])
+	case "batch":
+		if err := batchCmd(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	case "token":
+		if err := tokenCmd(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 	case "version", "--version", "-v":
 		fmt.Printf("idra %s\n", version)
 	case "help", "--help", "-h":
@@ -72,6 +82,9 @@ func runForeground() {
 			os.Exit(1)
 		}
 		mgr = agent.NewManager(reg)
+		if err := mgr.ApplyConfig(cfg); err != nil {
+			slog.Error("invalid agent config, using manifests as-is", "error", err)
+		}
 	}
 
 	srv, err := server.New(cfg, mgr)
@@ -84,19 +97,25 @@ func runForeground() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	// Agents run under their own context, cancelled only once StopAll has
+	// given them SIGTERM and their stop_timeout: cancelling it kills their
+	// process groups outright.
+	agentCtx, stopAgents := context.WithCancel(context.Background())
+	defer stopAgents()
+
 	// Start agents
 	if mgr != nil {
-		mgr.StartAll(ctx)
-		agent.StartHealthLoop(ctx, mgr, 30*time.Second)
+		mgr.StartAll(agentCtx)
+		agent.StartHealthLoop(agentCtx, mgr, 30*time.Second)
 	}
 
-	// Open browser
+	// Open browser, signed in with the one-time link that is also printed
+	loginURL := srv.LoginURL()
 	if cfg.AutoOpen {
 		go func() {
 			time.Sleep(300 * time.Millisecond)
-			url := "http://" + srv.Addr()
-			slog.Info("opening browser", "url", url)
-			if err := platform.OpenBrowser(url); err != nil {
+			slog.Info("opening browser", "url", "http://"+srv.Addr())
+			if err := platform.OpenBrowser(loginURL); err != nil {
 				slog.Warn("could not open browser", "error", err)
 			}
 		}()
@@ -107,7 +126,7 @@ func runForeground() {
 	go func() { errCh <- srv.ListenAndServe() }()
 
 	slog.Info("idra is running", "addr", srv.Addr(), "version", version)
-	fmt.Printf("\n  Idra is running at http://%s\n  Press Ctrl+C to stop.\n\n", srv.Addr())
+	fmt.Printf("\n  Idra is running at http://%s\n  Sign in to the web UI (link valid for 15 minutes, once):\n  %s\n  Press Ctrl+C to stop.\n\n", srv.Addr(), loginURL)
 
 	// Wait for shutdown signal or server error
 	select {
@@ -118,6 +137,7 @@ func runForeground() {
 		if mgr != nil {
 			mgr.StopAll()
 		}
+		stopAgents()
 
 		shutCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
@@ -130,6 +150,7 @@ func runForeground() {
 			if mgr != nil {
 				mgr.StopAll()
 			}
+			stopAgents()
 			os.Exit(1)
 		}
 	}
@@ -207,6 +228,11 @@ Usage:
   idra service uninstall      Uninstall the OS service
   idra service start          Start the OS service
   idra service stop           Stop the OS service
+  idra batch run <file.jsonl> Run every line of a JSONL file through a skill
+  idra token create           Create a scoped API token (-name, -scopes)
+  idra token list             List API tokens
+  idra token revoke <name>    Revoke an API token
+  idra token rotate           Replace the bearer token (-grace keeps the old one)
   idra version                Print version
   idra help                   Print this help`)
 }
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// apiToken mirrors the server's token listing.
+type apiToken struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Skills    []string   `json:"skills,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Expired   bool       `json:"expired,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+const tokenUsage = "usage: idra token <create|list|revoke|rotate> [flags]"
+
+// tokenCmd runs "idra token": it creates, lists and revokes the scoped API
+// tokens of the running server, and rotates its bearer token.
+func tokenCmd(args []string) error {
+	if len(args) == 0 {
+		return errors.New(tokenUsage)
+	}
+	fs := flag.NewFlagSet("token "+args[0], flag.ExitOnError)
+	addr := fs.String("addr", "", "address of the idra server (default: the port in config.json)")
+
+	switch args[0] {
+	case "create":
+		name := fs.String("name", "", "token name")
+		scopes := fs.String("scopes", "", "comma-separated scopes: read, tasks:execute, agents:manage, config:write")
+		skills := fs.String("skills", "", "comma-separated skills the token may run (default: all)")
+		expires := fs.Duration("expires", 0, "lifetime of the token, e.g. 720h (default: never)")
+		fs.Parse(args[1:])
+		if *name == "" || *scopes == "" {
+			return fmt.Errorf("usage: idra token create -name NAME -scopes SCOPES [-skills SKILLS] [-expires DURATION]")
+		}
+		c, err := newAPIClient(*addr)
+		if err != nil {
+			return err
+		}
+		body := map[string]any{"name": *name, "scopes": splitList(*scopes)}
+		if *skills != "" {
+			body["skills"] = splitList(*skills)
+		}
+		if *expires > 0 {
+			body["expires_in"] = expires.String()
+		}
+		var out struct {
+			Token string `json:"token"`
+		}
+		if err := c.do(http.MethodPost, "/tokens", body, &out); err != nil {
+			return err
+		}
+		fmt.Println(out.Token)
+		fmt.Fprintln(os.Stderr, "Store this token now; it will not be shown again.")
+		return nil
+
+	case "list":
+		fs.Parse(args[1:])
+		c, err := newAPIClient(*addr)
+		if err != nil {
+			return err
+		}
+		var tokens []apiToken
+		if err := c.do(http.MethodGet, "/tokens", nil, &tokens); err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tSCOPES\tSKILLS\tEXPIRES")
+		for _, t := range tokens {
+			skills, expires := "*", "never"
+			if len(t.Skills) > 0 {
+				skills = strings.Join(t.Skills, ",")
+			}
+			if t.ExpiresAt != nil {
+				expires = t.ExpiresAt.Local().Format(time.DateTime)
+				if t.Expired {
+					expires += " (expired)"
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Name, strings.Join(t.Scopes, ","), skills, expires)
+		}
+		return tw.Flush()
+
+	case "revoke":
+		fs.Parse(args[1:])
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: idra token revoke [flags] <name>")
+		}
+		c, err := newAPIClient(*addr)
+		if err != nil {
+			return err
+		}
+		if err := c.do(http.MethodDelete, "/tokens/"+url.PathEscape(fs.Arg(0)), nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Token %s revoked.\n", fs.Arg(0))
+		return nil
+
+	case "rotate":
+		grace := fs.Duration("grace", 0, "how long the old bearer token stays valid (default: not at all)")
+		fs.Parse(args[1:])
+		c, err := newAPIClient(*addr)
+		if err != nil {
+			return err
+		}
+		var out struct {
+			Token              string     `json:"token"`
+			PreviousValidUntil *time.Time `json:"previous_valid_until"`
+		}
+		if err := c.do(http.MethodPost, "/bearer-token/rotate", map[string]string{"grace": grace.String()}, &out); err != nil {
+			return err
+		}
+		fmt.Println(out.Token)
+		if out.PreviousValidUntil != nil {
+			fmt.Fprintf(os.Stderr, "The old bearer token stays valid until %s.\n", out.PreviousValidUntil.Local().Format(time.DateTime))
+		} else {
+			fmt.Fprintln(os.Stderr, "The old bearer token no longer works.")
+		}
+		return nil
+
+	default:
+		return errors.New(tokenUsage)
+	}
+}
+
+// splitList splits a comma-separated flag value, dropping empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"idra/internal/agent/pb"
+)
+
+// Aggregator combines the answers of a broadcast into one result.
+type Aggregator string
+
+const (
+	AggregateAll          Aggregator = "all"           // every answer, no combined result (default)
+	AggregateFirstSuccess Aggregator = "first-success" // the first agent to succeed; the rest are cancelled
+	AggregateMajority     Aggregator = "majority"      // the result payload returned by most agents
+)
+
+// ParseAggregator validates an aggregator name. The empty string means all.
+func ParseAggregator(s string) (Aggregator, error) {
+	switch Aggregator(s) {
+	case "":
+		return AggregateAll, nil
+	case AggregateAll, AggregateFirstSuccess, AggregateMajority:
+		return Aggregator(s), nil
+	default:
+		return "", fmt.Errorf("unknown aggregator %q", s)
+	}
+}
+
+// AgentAnswer is what one agent returned for a broadcast task.
+type AgentAnswer struct {
+	TaskID string          `json:"task_id"`
+	Events []*pb.TaskEvent `json:"events"`
+	Result string          `json:"result,omitempty"` // payload of the last "result" event
+	Error  string          `json:"error,omitempty"`
+}
+
+// Succeeded reports whether the agent finished without an error, timeout or
+// cancellation.
+func (a AgentAnswer) Succeeded() bool { return a.Error == "" }
+
+// Broadcast is the outcome of a task sent to every provider of a skill.
+type Broadcast struct {
+	Skill      string                 `json:"skill"`
+	Aggregator Aggregator             `json:"aggregator"`
+	Agents     map[string]AgentAnswer `json:"agents"` // keyed by agent name
+
+	Result string         `json:"result,omitempty"` // the aggregated result
+	Agent  string         `json:"agent,omitempty"`  // first-success: the agent whose result won
+	Votes  map[string]int `json:"votes,omitempty"`  // majority: result payload → number of agents
+	Error  string         `json:"error,omitempty"`  // why there is no aggregated result
+}
+
+// BroadcastExec runs a broadcast's task on one agent. A nil BroadcastExec
+// uses RouteTask.
+type BroadcastExec func(ctx context.Context, agentName string, req *pb.TaskRequest) ([]*pb.TaskEvent, error)
+
+// BroadcastSkill sends req to every running provider of req.Skill at once
+// and aggregates their answers with agg. Each agent gets a copy of req with
+// its own task ID, "<task ID>-<agent>", and the skill's retry policy applies
+// per agent. It fails only if no provider is running; per-agent failures
+// are reported in the answers.
+func (m *Manager) BroadcastSkill(ctx context.Context, req *pb.TaskRequest, agg Aggregator, exec BroadcastExec) (Broadcast, error) {
+	candidates := m.providers(req.Skill)
+	if len(candidates) == 0 {
+		return Broadcast{}, fmt.Errorf("%w %q", ErrUnknownSkill, req.Skill)
+	}
+	var names []string
+	for _, r := range candidates {
+		if r.State() == StateRunning {
+			names = append(names, r.Name())
+		}
+	}
+	if len(names) == 0 {
+		return Broadcast{}, fmt.Errorf("%w %q", ErrNoRunningAgent, req.Skill)
+	}
+	if exec == nil {
+		exec = m.RouteTask
+	}
+
+	// first-success cancels the others as soon as one agent succeeds.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	b := Broadcast{Skill: req.Skill, Aggregator: agg, Agents: make(map[string]AgentAnswer, len(names))}
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for _, name := range names {
+		sub := &pb.TaskRequest{
+			TaskId:   req.TaskId + "-" + name,
+			Skill:    req.Skill,
+			Input:    req.Input,
+			Metadata: req.Metadata,
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			events, err := exec(ctx, name, sub)
+			a := answer(sub.TaskId, events, err)
+
+			mu.Lock()
+			defer mu.Unlock()
+			b.Agents[name] = a
+			if agg == AggregateFirstSuccess && a.Succeeded() && b.Agent == "" {
+				b.Agent = name
+				b.Result = a.Result
+				cancel()
+			}
+		}()
+	}
+	wg.Wait()
+
+	switch agg {
+	case AggregateFirstSuccess:
+		if b.Agent == "" {
+			b.Error = "no agent succeeded"
+		}
+	case AggregateMajority:
+		b.Result, b.Votes, b.Error = majority(b.Agents)
+	}
+	return b, nil
+}
+
+// answer summarizes one agent's events. A task that ends with an "error",
+// "timeout" or "cancelled" event counts as failed.
+func answer(taskID string, events []*pb.TaskEvent, err error) AgentAnswer {
+	a := AgentAnswer{TaskID: taskID, Events: events}
+	if a.Events == nil {
+		a.Events = []*pb.TaskEvent{}
+	}
+	if err == nil {
+		if n := len(events); n > 0 {
+			switch last := events[n-1]; last.Type {
+			case "error", "timeout", "cancelled":
+				err = fmt.Errorf("%s: %s", last.Type, last.Payload)
+			}
+		}
+	}
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	for _, ev := range events {
+		if ev.Type == "result" {
+			a.Result = ev.Payload
+		}
+	}
+	return a
+}
+
+// majority counts the results of the agents that succeeded and returns the
+// one more than half of them agree on.
+func majority(answers map[string]AgentAnswer) (string, map[string]int, string) {
+	votes := make(map[string]int)
+	succeeded := 0
+	for _, a := range answers {
+		if a.Succeeded() {
+			votes[a.Result]++
+			succeeded++
+		}
+	}
+	if succeeded == 0 {
+		return "", nil, "no agent succeeded"
+	}
+	results := make([]string, 0, len(votes))
+	for r := range votes {
+		results = append(results, r)
+	}
+	sort.Slice(results, func(i, j int) bool { return votes[results[i]] > votes[results[j]] })
+	if top := results[0]; votes[top]*2 > succeeded {
+		return top, votes, ""
+	}
+	return "", votes, "no result has a majority"
+}
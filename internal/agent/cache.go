@@ -0,0 +1,404 @@
+package agent
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"idra/internal/agent/pb"
+	"idra/internal/config"
+)
+
+// CachePolicy caches the results of a skill's successful tasks.
+type CachePolicy struct {
+	TTL        time.Duration
+	MaxEntries int
+	Persist    bool // saved to disk by SaveCache and restored by LoadCache
+}
+
+// ParseCachePolicy validates a cache entry from config.json and fills in
+// defaults: a 5m TTL and 1000 entries. A nil config means no caching.
+func ParseCachePolicy(c *config.CacheConfig) (*CachePolicy, error) {
+	if c == nil {
+		return nil, nil
+	}
+	p := &CachePolicy{TTL: 5 * time.Minute, MaxEntries: c.MaxEntries, Persist: c.Persist}
+	if c.TTL != "" {
+		d, err := time.ParseDuration(c.TTL)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("cache: ttl must be a positive duration like \"10m\"")
+		}
+		p.TTL = d
+	}
+	if p.MaxEntries == 0 {
+		p.MaxEntries = 1000
+	}
+	if p.MaxEntries < 0 {
+		return nil, fmt.Errorf("cache: max_entries must not be negative")
+	}
+	return p, nil
+}
+
+// CacheStatus says how the result cache handled a task.
+type CacheStatus string
+
+const (
+	CacheHit    CacheStatus = "hit"    // events replayed from the cache
+	CacheMiss   CacheStatus = "miss"   // executed, and cached if it succeeds
+	CacheBypass CacheStatus = "bypass" // executed without looking at the cache
+)
+
+type cacheNotifyKey struct{}
+
+// WithCacheNotify returns a context that makes task execution call fn with
+// the cache status of a task of a cached skill. A notifier already in ctx is
+// called as well.
+func WithCacheNotify(ctx context.Context, fn func(CacheStatus)) context.Context {
+	if prev, ok := ctx.Value(cacheNotifyKey{}).(func(CacheStatus)); ok {
+		next := fn
+		fn = func(s CacheStatus) { prev(s); next(s) }
+	}
+	return context.WithValue(ctx, cacheNotifyKey{}, fn)
+}
+
+func notifyCache(ctx context.Context, s CacheStatus) {
+	if fn, ok := ctx.Value(cacheNotifyKey{}).(func(CacheStatus)); ok {
+		fn(s)
+	}
+}
+
+type cacheBypassKey struct{}
+
+// WithoutCache returns a context whose tasks skip the cache lookup. A
+// successful result still refreshes the cache.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, cacheBypassKey{}, true)
+}
+
+// cacheKey hashes what determines a task's result: the agent it was sent to
+// (empty for skill routing), the skill, the input and the metadata. The
+// priority key only affects queueing and is left out.
+func cacheKey(agentName string, req *pb.TaskRequest) string {
+	h := sha256.New()
+	for _, s := range []string{agentName, req.Skill, req.Input} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	keys := make([]string, 0, len(req.Metadata))
+	for k := range req.Metadata {
+		if k != "priority" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte{'='})
+		h.Write([]byte(req.Metadata[k]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// cacheEntry is a cached result, also the on-disk format.
+type cacheEntry struct {
+	Key     string          `json:"key"`
+	Skill   string          `json:"skill"`
+	Agent   string          `json:"agent"` // the agent that produced the events
+	Events  []*pb.TaskEvent `json:"events"`
+	Expires time.Time       `json:"expires"`
+
+	elem *list.Element // position in the skill's LRU list
+}
+
+// CacheStats reports the result cache of one skill.
+type CacheStats struct {
+	Skill      string `json:"skill"`
+	TTL        string `json:"ttl"`
+	MaxEntries int    `json:"max_entries"`
+	Persist    bool   `json:"persist,omitempty"`
+	Entries    int    `json:"entries"`
+	Hits       int64  `json:"hits"`
+	Misses     int64  `json:"misses"`
+	Evictions  int64  `json:"evictions"` // entries dropped to stay under max_entries
+}
+
+// resultCache holds cached results per skill, each skill with its own LRU
+// list bounded by its policy.
+type resultCache struct {
+	mu       sync.Mutex
+	path     string                  // set by LoadCache; empty keeps the cache in memory
+	policies map[string]*CachePolicy // skill → policy; missing means no caching
+	entries  map[string]*cacheEntry  // key → entry
+	lru      map[string]*list.List   // skill → entries, most recently used first
+	stats    map[string]*CacheStats  // skill → counters
+}
+
+func newResultCache() *resultCache {
+	return &resultCache{
+		entries: make(map[string]*cacheEntry),
+		lru:     make(map[string]*list.List),
+		stats:   make(map[string]*CacheStats),
+	}
+}
+
+// setPolicies replaces the policies and drops the entries of skills that are
+// no longer cached or now over their limit.
+func (c *resultCache) setPolicies(policies map[string]*CachePolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policies = policies
+	for skill, l := range c.lru {
+		p := policies[skill]
+		for l.Len() > 0 && (p == nil || l.Len() > p.MaxEntries) {
+			c.remove(l.Back().Value.(*cacheEntry))
+		}
+	}
+}
+
+func (c *resultCache) policy(skill string) *CachePolicy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.policies[skill]
+}
+
+// get returns an unexpired entry and counts the hit or miss.
+func (c *resultCache) get(skill, key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	st := c.statsFor(skill)
+	e, ok := c.entries[key]
+	if ok && time.Now().After(e.Expires) {
+		c.remove(e)
+		ok = false
+	}
+	if !ok {
+		st.Misses++
+		return nil, false
+	}
+	st.Hits++
+	c.lru[skill].MoveToFront(e.elem)
+	return e, true
+}
+
+func (c *resultCache) put(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.policies[e.Skill]
+	if p == nil {
+		return
+	}
+	if old, ok := c.entries[e.Key]; ok {
+		c.remove(old)
+	}
+	l := c.lru[e.Skill]
+	if l == nil {
+		l = list.New()
+		c.lru[e.Skill] = l
+	}
+	e.elem = l.PushFront(e)
+	c.entries[e.Key] = e
+	for l.Len() > p.MaxEntries {
+		c.remove(l.Back().Value.(*cacheEntry))
+		c.statsFor(e.Skill).Evictions++
+	}
+}
+
+// remove drops an entry. Caller must hold mu.
+func (c *resultCache) remove(e *cacheEntry) {
+	delete(c.entries, e.Key)
+	c.lru[e.Skill].Remove(e.elem)
+}
+
+// statsFor returns the counters of skill. Caller must hold mu.
+func (c *resultCache) statsFor(skill string) *CacheStats {
+	st, ok := c.stats[skill]
+	if !ok {
+		st = &CacheStats{Skill: skill}
+		c.stats[skill] = st
+	}
+	return st
+}
+
+// cacheable reports whether the events are a successful result worth
+// caching: at least one "result" event and no error, timeout or
+// cancellation at the end.
+func cacheable(events []*pb.TaskEvent) bool {
+	n := len(events)
+	if n == 0 {
+		return false
+	}
+	switch events[n-1].Type {
+	case "error", "timeout", "cancelled":
+		return false
+	}
+	return slices.ContainsFunc(events, func(ev *pb.TaskEvent) bool { return ev.Type == "result" })
+}
+
+// cached serves req from the result cache if its skill is cached, or runs it
+// with run and caches a successful outcome. agentName is empty for skill
+// routing. Agents that list the skill in their manifest's no_cache are
+// never served from or stored in the cache.
+func (m *Manager) cached(ctx context.Context, agentName string, req *pb.TaskRequest, fn func(*pb.TaskEvent) error,
+	run func(fn func(*pb.TaskEvent) error) (string, error)) (string, error) {
+	p := m.cache.policy(req.Skill)
+	if p == nil {
+		return run(fn)
+	}
+	if agentName != "" && m.noCache(agentName, req.Skill) {
+		notifyCache(ctx, CacheBypass)
+		return run(fn)
+	}
+
+	key := cacheKey(agentName, req)
+	if bypass, _ := ctx.Value(cacheBypassKey{}).(bool); bypass {
+		notifyCache(ctx, CacheBypass)
+	} else if e, ok := m.cache.get(req.Skill, key); ok {
+		notifyCache(ctx, CacheHit)
+		for _, ev := range e.Events {
+			if err := fn(&pb.TaskEvent{TaskId: req.TaskId, Type: ev.Type, Payload: ev.Payload}); err != nil {
+				return e.Agent, err
+			}
+		}
+		return e.Agent, nil
+	} else {
+		notifyCache(ctx, CacheMiss)
+	}
+
+	var events []*pb.TaskEvent
+	name, err := run(func(ev *pb.TaskEvent) error {
+		events = append(events, ev)
+		return fn(ev)
+	})
+	if err == nil && cacheable(events) && !m.noCache(name, req.Skill) {
+		m.cache.put(&cacheEntry{
+			Key:     key,
+			Skill:   req.Skill,
+			Agent:   name,
+			Events:  events,
+			Expires: time.Now().Add(p.TTL),
+		})
+	}
+	return name, err
+}
+
+// noCache reports whether the named agent opted skill out of caching.
+func (m *Manager) noCache(agentName, skill string) bool {
+	r, ok := m.Runner(agentName)
+	return ok && slices.Contains(r.Manifest().NoCache, skill)
+}
+
+// CacheStats reports the result cache of every cached skill, sorted by
+// skill.
+func (m *Manager) CacheStats() []CacheStats {
+	c := m.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]CacheStats, 0, len(c.policies))
+	for skill, p := range c.policies {
+		st := *c.statsFor(skill)
+		st.TTL = p.TTL.String()
+		st.MaxEntries = p.MaxEntries
+		st.Persist = p.Persist
+		if l := c.lru[skill]; l != nil {
+			st.Entries = l.Len()
+		}
+		out = append(out, st)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Skill < out[j].Skill })
+	return out
+}
+
+// PurgeCache drops the cached results of skill, or of every skill if skill
+// is empty, and returns how many were dropped.
+func (m *Manager) PurgeCache(skill string) int {
+	c := m.cache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, e := range c.entries {
+		if skill == "" || e.Skill == skill {
+			c.remove(e)
+			n++
+		}
+	}
+	return n
+}
+
+// LoadCache restores the results saved by SaveCache at path and makes
+// SaveCache write there. Expired entries and entries of skills that are not
+// persisted are dropped. A missing file is not an error.
+func (m *Manager) LoadCache(path string) error {
+	c := m.cache
+	c.mu.Lock()
+	c.path = path
+	c.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read cache: %w", err)
+	}
+	var entries []*cacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("parse cache: %w", err)
+	}
+	// Saved most recently used first; insert in reverse to keep the order.
+	now := time.Now()
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if p := c.policy(e.Skill); p != nil && p.Persist && now.Before(e.Expires) {
+			c.put(e)
+		}
+	}
+	return nil
+}
+
+// SaveCache writes the unexpired results of persisted skills to the path
+// given to LoadCache. It does nothing if LoadCache was not called.
+func (m *Manager) SaveCache() error {
+	c := m.cache
+	c.mu.Lock()
+	path := c.path
+	var entries []*cacheEntry
+	now := time.Now()
+	for skill, l := range c.lru {
+		if p := c.policies[skill]; p == nil || !p.Persist {
+			continue
+		}
+		for el := l.Front(); el != nil; el = el.Next() {
+			if e := el.Value.(*cacheEntry); now.Before(e.Expires) {
+				entries = append(entries, e)
+			}
+		}
+	}
+	c.mu.Unlock()
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write cache: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
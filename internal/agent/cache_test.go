@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"idra/internal/agent/pb"
+	"idra/internal/config"
+)
+
+func TestParseCachePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *config.CacheConfig
+		want    *CachePolicy
+		wantErr bool
+	}{
+		{"none", nil, nil, false},
+		{"defaults", &config.CacheConfig{}, &CachePolicy{TTL: 5 * time.Minute, MaxEntries: 1000}, false},
+		{"custom", &config.CacheConfig{TTL: "1h", MaxEntries: 10, Persist: true}, &CachePolicy{TTL: time.Hour, MaxEntries: 10, Persist: true}, false},
+		{"bad ttl", &config.CacheConfig{TTL: "later"}, nil, true},
+		{"zero ttl", &config.CacheConfig{TTL: "0s"}, nil, true},
+		{"negative max entries", &config.CacheConfig{MaxEntries: -1}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCachePolicy(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCachePolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Errorf("ParseCachePolicy() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResultCacheEviction(t *testing.T) {
+	// Steps: "+k" puts k, "?k" gets k.
+	tests := []struct {
+		name          string
+		maxEntries    int
+		steps         []string
+		wantKeys      []string // most recently used first
+		wantEvictions int64
+	}{
+		{"under the limit", 3, []string{"+a", "+b"}, []string{"b", "a"}, 0},
+		{"evicts the oldest", 2, []string{"+a", "+b", "+c"}, []string{"c", "b"}, 1},
+		{"a hit refreshes", 2, []string{"+a", "+b", "?a", "+c"}, []string{"c", "a"}, 1},
+		{"a miss does not", 2, []string{"+a", "+b", "?x", "+c"}, []string{"c", "b"}, 1},
+		{"re-put replaces", 2, []string{"+a", "+b", "+a", "+c"}, []string{"c", "a"}, 1},
+		{"limit of one", 1, []string{"+a", "+b", "+c"}, []string{"c"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newResultCache()
+			c.setPolicies(map[string]*CachePolicy{"summarize": {TTL: time.Hour, MaxEntries: tt.maxEntries}})
+			for _, s := range tt.steps {
+				key := s[1:]
+				if s[0] == '+' {
+					c.put(&cacheEntry{Key: key, Skill: "summarize", Expires: time.Now().Add(time.Hour)})
+				} else {
+					c.get("summarize", key)
+				}
+			}
+			if got := lruKeys(c, "summarize"); !slices.Equal(got, tt.wantKeys) {
+				t.Errorf("entries = %v, want %v", got, tt.wantKeys)
+			}
+			if got := c.statsFor("summarize").Evictions; got != tt.wantEvictions {
+				t.Errorf("evictions = %d, want %d", got, tt.wantEvictions)
+			}
+		})
+	}
+}
+
+func TestResultCacheExpiry(t *testing.T) {
+	c := newResultCache()
+	c.setPolicies(map[string]*CachePolicy{"summarize": {TTL: time.Hour, MaxEntries: 10}})
+	c.put(&cacheEntry{Key: "fresh", Skill: "summarize", Expires: time.Now().Add(time.Hour)})
+	c.put(&cacheEntry{Key: "stale", Skill: "summarize", Expires: time.Now().Add(-time.Second)})
+
+	if _, ok := c.get("summarize", "stale"); ok {
+		t.Error("expired entry was served")
+	}
+	if _, ok := c.get("summarize", "fresh"); !ok {
+		t.Error("fresh entry was not served")
+	}
+	if got := lruKeys(c, "summarize"); !slices.Equal(got, []string{"fresh"}) {
+		t.Errorf("entries = %v, want the expired one dropped", got)
+	}
+	if st := c.statsFor("summarize"); st.Hits != 1 || st.Misses != 1 || st.Evictions != 0 {
+		t.Errorf("stats = %+v, want 1 hit, 1 miss, no evictions", *st)
+	}
+}
+
+func TestResultCacheSetPolicies(t *testing.T) {
+	c := newResultCache()
+	c.setPolicies(map[string]*CachePolicy{
+		"summarize": {TTL: time.Hour, MaxEntries: 3},
+		"translate": {TTL: time.Hour, MaxEntries: 3},
+	})
+	for _, k := range []string{"a", "b", "c"} {
+		c.put(&cacheEntry{Key: "s-" + k, Skill: "summarize", Expires: time.Now().Add(time.Hour)})
+		c.put(&cacheEntry{Key: "t-" + k, Skill: "translate", Expires: time.Now().Add(time.Hour)})
+	}
+	// Shrinking a limit drops the least recently used; removing a policy
+	// drops the skill's entries.
+	c.setPolicies(map[string]*CachePolicy{"summarize": {TTL: time.Hour, MaxEntries: 1}})
+	if got := lruKeys(c, "summarize"); !slices.Equal(got, []string{"s-c"}) {
+		t.Errorf("summarize entries = %v, want [s-c]", got)
+	}
+	if got := lruKeys(c, "translate"); len(got) != 0 {
+		t.Errorf("translate entries = %v, want none", got)
+	}
+	c.put(&cacheEntry{Key: "t-d", Skill: "translate", Expires: time.Now().Add(time.Hour)})
+	if _, ok := c.entries["t-d"]; ok {
+		t.Error("entry stored for a skill without a cache policy")
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	base := &pb.TaskRequest{Skill: "summarize", Input: "text", Metadata: map[string]string{"lang": "en", "priority": "high"}}
+	key := cacheKey("", base)
+	tests := []struct {
+		name      string
+		agent     string
+		req       *pb.TaskRequest
+		wantEqual bool
+	}{
+		{"same request", "", &pb.TaskRequest{Skill: "summarize", Input: "text", Metadata: map[string]string{"lang": "en", "priority": "high"}}, true},
+		{"priority ignored", "", &pb.TaskRequest{Skill: "summarize", Input: "text", Metadata: map[string]string{"lang": "en"}}, true},
+		{"task ID ignored", "", &pb.TaskRequest{TaskId: "task-2", Skill: "summarize", Input: "text", Metadata: map[string]string{"lang": "en"}}, true},
+		{"agent", "echo", base, false},
+		{"skill", "", &pb.TaskRequest{Skill: "translate", Input: "text", Metadata: map[string]string{"lang": "en"}}, false},
+		{"input", "", &pb.TaskRequest{Skill: "summarize", Input: "other", Metadata: map[string]string{"lang": "en"}}, false},
+		{"metadata", "", &pb.TaskRequest{Skill: "summarize", Input: "text", Metadata: map[string]string{"lang": "it"}}, false},
+		{"no field boundary confusion", "", &pb.TaskRequest{Skill: "summarizet", Input: "ext", Metadata: map[string]string{"lang": "en"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cacheKey(tt.agent, tt.req) == key; got != tt.wantEqual {
+				t.Errorf("same key = %v, want %v", got, tt.wantEqual)
+			}
+		})
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	ev := func(types ...string) []*pb.TaskEvent {
+		out := make([]*pb.TaskEvent, len(types))
+		for i, typ := range types {
+			out[i] = &pb.TaskEvent{Type: typ}
+		}
+		return out
+	}
+	tests := []struct {
+		name   string
+		events []*pb.TaskEvent
+		want   bool
+	}{
+		{"no events", nil, false},
+		{"result", ev("result"), true},
+		{"progress then result", ev("progress", "result"), true},
+		{"progress only", ev("progress"), false},
+		{"ends in error", ev("result", "error"), false},
+		{"ends in timeout", ev("progress", "timeout"), false},
+		{"cancelled", ev("result", "cancelled"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cacheable(tt.events); got != tt.want {
+				t.Errorf("cacheable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// lruKeys returns the keys cached for skill, most recently used first.
+func lruKeys(c *resultCache, skill string) []string {
+	var out []string
+	if l := c.lru[skill]; l != nil {
+		for el := l.Front(); el != nil; el = el.Next() {
+			out = append(out, el.Value.(*cacheEntry).Key)
+		}
+	}
+	return out
+}
@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"time"
+)
+
+type startNotifyKey struct{}
+
+// WithStartNotify returns a context that makes ExecuteStream call fn with the
+// agent's name when the task leaves the queue and is sent to the agent. With
+// skill failover fn may be called once per agent tried.
+func WithStartNotify(ctx context.Context, fn func(agentName string)) context.Context {
+	return context.WithValue(ctx, startNotifyKey{}, fn)
+}
+
+func notifyStart(ctx context.Context, agentName string) {
+	if fn, ok := ctx.Value(startNotifyKey{}).(func(string)); ok {
+		fn(agentName)
+	}
+}
+
+// Attempt is one try at executing a task: on an agent, or failing before
+// any agent could be reached.
+type Attempt struct {
+	Agent    string // empty if no agent was available
+	Started  time.Time
+	Finished time.Time
+	Err      error // nil if the agent's stream ended normally
+}
+
+type attemptNotifyKey struct{}
+
+// WithAttemptNotify returns a context that makes task execution call fn after
+// every attempt, including retries and failover to another provider.
+func WithAttemptNotify(ctx context.Context, fn func(Attempt)) context.Context {
+	return context.WithValue(ctx, attemptNotifyKey{}, fn)
+}
+
+func notifyAttempt(ctx context.Context, a Attempt) {
+	if fn, ok := ctx.Value(attemptNotifyKey{}).(func(Attempt)); ok {
+		a.Finished = time.Now()
+		fn(a)
+	}
+}
+
+type taskTimeoutKey struct{}
+
+// WithTaskTimeout returns a context carrying the timeout requested for a
+// task. The Manager applies it once across retries and failover, and
+// ExecuteStream resolves it against the agent's per-skill limits and
+// propagates the result to the agent as the gRPC deadline.
+func WithTaskTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, taskTimeoutKey{}, d)
+}
+
+func requestedTimeout(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(taskTimeoutKey{}).(time.Duration)
+	return d
+}
+
+// withRequestedTimeout bounds ctx by the task's requested timeout, if any,
+// so that every attempt of the task shares one deadline.
+func withRequestedTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d := requestedTimeout(ctx); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return ctx, func() {}
+}
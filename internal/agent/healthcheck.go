@@ -8,7 +8,7 @@ import (
 
 // StartHealthLoop runs a background goroutine that pings every running agent
 // every interval (typically 30s). Agents that fail the health check are
-// marked as Failed.
+// marked as Failed, killed and restarted according to their restart policy.
 func StartHealthLoop(ctx context.Context, mgr *Manager, interval time.Duration) {
 	go func() {
 		ticker := time.NewTicker(interval)
@@ -48,7 +48,7 @@ func checkAll(ctx context.Context, mgr *Manager) {
 
 		if err != nil {
 			slog.Warn("health check failed", "agent", r.Name(), "error", err)
-			r.setFailed(err)
+			r.fail(err)
 		}
 	}
 }
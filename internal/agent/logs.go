@@ -0,0 +1,247 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"idra/internal/platform"
+)
+
+const (
+	logBufferLines = 2000             // lines kept in memory per agent
+	logFileMaxSize = 10 * 1024 * 1024 // rotate the on-disk log after 10MB
+	logFileBackups = 3                // rotated files kept: name.log.1 … name.log.3
+)
+
+// LogLine is a single line of agent output.
+type LogLine struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"` // "stdout" or "stderr"
+	Line   string    `json:"line"`
+}
+
+// LogQuery selects lines from a LogBuffer. Zero values match everything.
+type LogQuery struct {
+	Tail   int            // return at most the last Tail lines
+	Since  time.Time      // only lines at or after Since
+	Stream string         // only lines from this stream
+	Filter *regexp.Regexp // only lines matching Filter
+}
+
+// Match reports whether l satisfies the query's stream, time and filter.
+func (q LogQuery) Match(l LogLine) bool {
+	if q.Stream != "" && l.Stream != q.Stream {
+		return false
+	}
+	if !q.Since.IsZero() && l.Time.Before(q.Since) {
+		return false
+	}
+	if q.Filter != nil && !q.Filter.MatchString(l.Line) {
+		return false
+	}
+	return true
+}
+
+// LogBuffer keeps the most recent output lines of an agent in a ring buffer,
+// mirrors them to a rotating file under the data directory and fans them out
+// to followers.
+type LogBuffer struct {
+	mu    sync.Mutex
+	lines []LogLine
+	next  int  // ring write position
+	full  bool // ring has wrapped
+	subs  map[chan LogLine]struct{}
+
+	path string
+	file *os.File
+	size int64
+}
+
+// NewLogBuffer creates a buffer for the named agent. The log file is
+// created lazily in <DataDir>/logs/<name>.log.
+func NewLogBuffer(name string) *LogBuffer {
+	return &LogBuffer{
+		lines: make([]LogLine, logBufferLines),
+		subs:  make(map[chan LogLine]struct{}),
+		path:  filepath.Join(platform.DataDir(), "logs", name+".log"),
+	}
+}
+
+// Append records a line from the given stream.
+func (b *LogBuffer) Append(stream, line string) {
+	l := LogLine{Time: time.Now().UTC(), Stream: stream, Line: line}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lines[b.next] = l
+	b.next = (b.next + 1) % len(b.lines)
+	if b.next == 0 {
+		b.full = true
+	}
+	b.writeFile(l)
+
+	for ch := range b.subs {
+		select {
+		case ch <- l:
+		default: // slow follower, drop rather than block the agent
+		}
+	}
+}
+
+// Lines returns buffered lines matching q, oldest first.
+func (b *LogBuffer) Lines(q LogQuery) []LogLine {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var ordered []LogLine
+	if b.full {
+		ordered = append(ordered, b.lines[b.next:]...)
+	}
+	ordered = append(ordered, b.lines[:b.next]...)
+
+	out := make([]LogLine, 0, len(ordered))
+	for _, l := range ordered {
+		if q.Match(l) {
+			out = append(out, l)
+		}
+	}
+	if q.Tail > 0 && len(out) > q.Tail {
+		out = out[len(out)-q.Tail:]
+	}
+	return out
+}
+
+// Subscribe returns a channel that receives every new line until the
+// returned cancel function is called.
+func (b *LogBuffer) Subscribe() (<-chan LogLine, func()) {
+	ch := make(chan LogLine, 256)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subs, ch)
+		b.mu.Unlock()
+	}
+}
+
+// Close closes the log file.
+func (b *LogBuffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.file != nil {
+		b.file.Close()
+		b.file = nil
+	}
+}
+
+// writeFile appends l to the log file, rotating when it grows past
+// logFileMaxSize. Caller must hold mu.
+func (b *LogBuffer) writeFile(l LogLine) {
+	if b.file == nil {
+		if err := b.openFile(); err != nil {
+			return
+		}
+	}
+
+	entry := fmt.Sprintf("%s %s %s\n", l.Time.Format(time.RFC3339Nano), l.Stream, l.Line)
+	n, err := b.file.WriteString(entry)
+	b.size += int64(n)
+	if err != nil {
+		slog.Warn("write agent log", "path", b.path, "error", err)
+	}
+
+	if b.size >= logFileMaxSize {
+		b.rotate()
+	}
+}
+
+// openFile opens (or creates) the log file for appending. Caller must hold mu.
+func (b *LogBuffer) openFile() error {
+	if err := os.MkdirAll(filepath.Dir(b.path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(b.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	b.file = f
+	b.size = info.Size()
+	return nil
+}
+
+// rotate shifts name.log → name.log.1 → … and drops the oldest backup.
+// Caller must hold mu.
+func (b *LogBuffer) rotate() {
+	b.file.Close()
+	b.file = nil
+
+	for i := logFileBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", b.path, i), fmt.Sprintf("%s.%d", b.path, i+1))
+	}
+	if err := os.Rename(b.path, b.path+".1"); err != nil {
+		slog.Warn("rotate agent log", "path", b.path, "error", err)
+	}
+	b.size = 0
+}
+
+// lineWriter splits process output into lines and appends them to a
+// LogBuffer. onLine, if set, sees every complete line (used for the
+// AGENT_PORT handshake).
+type lineWriter struct {
+	name   string
+	stream string
+	buf    *LogBuffer
+	onLine func(string)
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(bytes.TrimRight(w.partial[:i], "\r"))
+		w.partial = w.partial[i+1:]
+		w.emit(line)
+	}
+	return len(p), nil
+}
+
+// Flush emits any trailing output that was not terminated by a newline.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.emit(string(w.partial))
+		w.partial = nil
+	}
+}
+
+func (w *lineWriter) emit(line string) {
+	slog.Debug("agent "+w.stream, "agent", w.name, "line", line)
+	w.buf.Append(w.stream, line)
+	if w.onLine != nil {
+		w.onLine(line)
+	}
+}
@@ -2,18 +2,51 @@ package agent
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
+	"reflect"
+	"slices"
+	"sort"
 	"sync"
+	"time"
 
 	"idra/internal/agent/pb"
+	"idra/internal/config"
+)
+
+// Routing errors, distinguishable with errors.Is.
+var (
+	ErrUnknownAgent     = errors.New("unknown agent")
+	ErrUnknownSkill     = errors.New("no agent provides skill")
+	ErrNoRunningAgent   = errors.New("no running agent provides skill")
+	ErrAgentNotRunning  = errors.New("agent is not running")
+	ErrUnsupportedSkill = errors.New("agent does not provide skill")
+	ErrQueueFull        = errors.New("task queue is full")
+	ErrTimeout          = errors.New("task timed out")
+	ErrInvalidPriority  = errors.New("unknown priority")
+)
+
+// Cancellation errors.
+var (
+	ErrUnknownTask       = errors.New("task is not executing")
+	ErrCancelUnsupported = errors.New("agent does not implement Cancel")
 )
 
 // Manager orchestrates all agent runners.
 type Manager struct {
 	registry *Registry
 	runners  map[string]*Runner // agent name → runner
+	router   *router
+	retrier  *retrier
+	cache    *resultCache
 	mu       sync.RWMutex
+
+	ctx     context.Context // lifetime of started agents, set by StartAll
+	started bool            // StartAll has run; config changes may start agents
+
+	applyMu sync.Mutex // serializes ApplyConfig
 }
 
 // NewManager creates a manager from a registry.
@@ -25,50 +58,470 @@ func NewManager(reg *Registry) *Manager {
 	return &Manager{
 		registry: reg,
 		runners:  runners,
+		router:   newRouter(),
+		retrier:  &retrier{},
+		cache:    newResultCache(),
+		ctx:      context.Background(),
 	}
 }
 
 // StartAll starts all registered agents. Errors are logged but don't stop other agents.
 func (m *Manager) StartAll(ctx context.Context) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	// Runners start without the lock held: a slow agent must not block
+	// status requests or config reloads for its whole start timeout.
+	m.mu.Lock()
+	m.ctx = ctx
+	m.started = true
+	runners := maps.Clone(m.runners)
+	m.mu.Unlock()
 
 	var wg sync.WaitGroup
-	for name, runner := range m.runners {
+	for name, runner := range runners {
+		if !runner.Status().Enabled {
+			slog.Info("agent disabled in config, not starting", "agent", name)
+			continue
+		}
 		wg.Add(1)
 		go func(name string, r *Runner) {
 			defer wg.Done()
+			if cur, _ := m.Runner(name); cur != r {
+				return // replaced by a config change since the copy
+			}
 			if err := r.Start(ctx); err != nil {
 				slog.Error("failed to start agent", "agent", name, "error", err)
 			}
 		}(name, runner)
 	}
 	wg.Wait()
-	slog.Info("all agents started", "count", len(m.runners))
+	slog.Info("all agents started", "count", len(runners))
 }
 
-// StopAll stops all running agents.
+// StopAll stops all running agents in parallel, so the total shutdown time is
+// bounded by the longest stop timeout rather than their sum.
 func (m *Manager) StopAll() {
 	m.mu.RLock()
-	defer m.mu.RUnlock()
+	runners := maps.Clone(m.runners)
+	m.mu.RUnlock()
 
-	for _, runner := range m.runners {
-		runner.Stop()
+	var wg sync.WaitGroup
+	for _, runner := range runners {
+		wg.Add(1)
+		go func(r *Runner) {
+			defer wg.Done()
+			r.Stop()
+		}(runner)
 	}
+	wg.Wait()
 	slog.Info("all agents stopped")
 }
 
-// RouteTask finds the agent that handles the given skill and executes the task.
-func (m *Manager) RouteTask(ctx context.Context, agentName string, req *pb.TaskRequest) ([]*pb.TaskEvent, error) {
+// ValidateConfig checks that the agent and skill entries of c apply cleanly
+// to the registered manifests without changing anything.
+func (m *Manager) ValidateConfig(c config.Config) error {
+	if _, err := m.effectiveManifests(c.Agents); err != nil {
+		return err
+	}
+	if _, err := skillStrategies(c.Skills); err != nil {
+		return err
+	}
+	if _, err := skillRetries(c.Skills); err != nil {
+		return err
+	}
+	_, err := skillCaches(c.Skills)
+	return err
+}
+
+// ApplyConfig applies config.json to the fleet: routing strategies, retry
+// and cache policies are replaced, disabled agents are stopped, re-enabled
+// agents are started, and agents whose effective manifest changed are
+// recreated and restarted if they were running. Nothing is applied if any entry is invalid.
+func (m *Manager) ApplyConfig(c config.Config) error {
+	m.applyMu.Lock()
+	defer m.applyMu.Unlock()
+
+	manifests, err := m.effectiveManifests(c.Agents)
+	if err != nil {
+		return err
+	}
+	strategies, err := skillStrategies(c.Skills)
+	if err != nil {
+		return err
+	}
+	retries, err := skillRetries(c.Skills)
+	if err != nil {
+		return err
+	}
+	caches, err := skillCaches(c.Skills)
+	if err != nil {
+		return err
+	}
+	m.router.setStrategies(strategies)
+	m.retrier.setPolicies(retries)
+	m.cache.setPolicies(caches)
+
+	enabled := make(map[string]bool, len(manifests))
+	for name := range manifests {
+		enabled[name] = true
+	}
+	for _, ac := range c.Agents {
+		if _, ok := manifests[ac.Name]; ok {
+			enabled[ac.Name] = ac.IsEnabled()
+		}
+	}
+
+	for name, manifest := range manifests {
+		old, _ := m.Runner(name)
+		wasActive := old.active()
+		wasEnabled := old.Status().Enabled
+		changed := !reflect.DeepEqual(old.Manifest(), manifest)
+		if !changed && wasEnabled == enabled[name] {
+			continue
+		}
+
+		runner := old
+		if changed {
+			if wasActive {
+				old.Stop()
+			}
+			runner = NewRunner(manifest, m.registry.BaseDir())
+			runner.logs = old.logs
+			m.mu.Lock()
+			m.runners[name] = runner
+			m.mu.Unlock()
+			slog.Info("agent manifest updated from config", "agent", name)
+		}
+		runner.setEnabled(enabled[name])
+
+		m.mu.RLock()
+		started := m.started
+		m.mu.RUnlock()
+
+		switch {
+		case !enabled[name]:
+			runner.Stop()
+			slog.Info("agent disabled by config", "agent", name)
+		case started && (wasActive || !wasEnabled):
+			if _, err := m.StartAgent(name); err != nil {
+				slog.Error("failed to start agent", "agent", name, "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// effectiveManifests merges config overrides onto the registered manifests.
+func (m *Manager) effectiveManifests(cfgs []config.AgentConfig) (map[string]Manifest, error) {
+	out := make(map[string]Manifest, len(m.registry.Agents()))
+	for _, base := range m.registry.Agents() {
+		out[base.Name] = base
+	}
+	for _, ac := range cfgs {
+		base, ok := out[ac.Name]
+		if !ok {
+			slog.Warn("config entry for unknown agent ignored", "agent", ac.Name)
+			continue
+		}
+		eff, err := base.WithOverrides(ac.Overrides)
+		if err != nil {
+			return nil, fmt.Errorf("agent %s: %w", ac.Name, err)
+		}
+		out[ac.Name] = eff
+	}
+	return out, nil
+}
+
+func skillStrategies(cfgs []config.SkillConfig) (map[string]Strategy, error) {
+	out := make(map[string]Strategy, len(cfgs))
+	for _, sc := range cfgs {
+		st, err := ParseStrategy(sc.Strategy)
+		if err != nil {
+			return nil, fmt.Errorf("skill %s: %w", sc.Name, err)
+		}
+		out[sc.Name] = st
+	}
+	return out, nil
+}
+
+// skillRetries parses the per-skill retry policies from config.json.
+func skillRetries(cfgs []config.SkillConfig) (map[string]*RetryPolicy, error) {
+	out := make(map[string]*RetryPolicy, len(cfgs))
+	for _, sc := range cfgs {
+		p, err := ParseRetryPolicy(sc.Retry)
+		if err != nil {
+			return nil, fmt.Errorf("skill %s: %w", sc.Name, err)
+		}
+		if p != nil {
+			out[sc.Name] = p
+		}
+	}
+	return out, nil
+}
+
+// skillCaches parses the per-skill result cache policies from config.json.
+func skillCaches(cfgs []config.SkillConfig) (map[string]*CachePolicy, error) {
+	out := make(map[string]*CachePolicy, len(cfgs))
+	for _, sc := range cfgs {
+		p, err := ParseCachePolicy(sc.Cache)
+		if err != nil {
+			return nil, fmt.Errorf("skill %s: %w", sc.Name, err)
+		}
+		if p != nil {
+			out[sc.Name] = p
+		}
+	}
+	return out, nil
+}
+
+// StartAgent starts a single agent under the manager's lifetime context.
+// Starting an agent that is already running is a no-op.
+func (m *Manager) StartAgent(name string) (AgentStatus, error) {
 	m.mu.RLock()
-	runner, ok := m.runners[agentName]
+	runner, ok := m.runners[name]
+	ctx := m.ctx
 	m.mu.RUnlock()
 
 	if !ok {
-		return nil, fmt.Errorf("unknown agent: %s", agentName)
+		return AgentStatus{}, fmt.Errorf("%w: %s", ErrUnknownAgent, name)
 	}
+	err := runner.Start(ctx)
+	return runner.Status(), err
+}
 
-	return runner.Execute(ctx, req)
+// StopAgent stops a single agent. Stopping a stopped agent is a no-op.
+func (m *Manager) StopAgent(name string) (AgentStatus, error) {
+	runner, ok := m.Runner(name)
+	if !ok {
+		return AgentStatus{}, fmt.Errorf("%w: %s", ErrUnknownAgent, name)
+	}
+	runner.Stop()
+	return runner.Status(), nil
+}
+
+// RestartAgent stops and starts a single agent.
+func (m *Manager) RestartAgent(name string) (AgentStatus, error) {
+	if _, err := m.StopAgent(name); err != nil {
+		return AgentStatus{}, err
+	}
+	return m.StartAgent(name)
+}
+
+// CheckRoute reports whether a task for skill can be routed: to the named
+// agent, or, if agentName is empty, to any provider of the skill. It does not
+// check whether the agent is running.
+func (m *Manager) CheckRoute(agentName, skill string) error {
+	if agentName == "" {
+		if len(m.registry.AgentsForSkill(skill)) == 0 {
+			return fmt.Errorf("%w %q", ErrUnknownSkill, skill)
+		}
+		return nil
+	}
+	runner, ok := m.Runner(agentName)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownAgent, agentName)
+	}
+	if !slices.Contains(runner.Manifest().Skills, skill) {
+		return fmt.Errorf("%w %q: %s", ErrUnsupportedSkill, skill, agentName)
+	}
+	return nil
+}
+
+// CheckCapacity reports ErrQueueFull if a task would be rejected right away:
+// the named agent's queue is full or, if agentName is empty, the queues of
+// all running providers of skill are. It is advisory; admission is decided
+// when the task is executed.
+func (m *Manager) CheckCapacity(agentName, skill string) error {
+	if agentName != "" {
+		if r, ok := m.Runner(agentName); ok && r.queue.full() {
+			return fmt.Errorf("%w: %s", ErrQueueFull, agentName)
+		}
+		return nil
+	}
+	running := 0
+	for _, r := range m.providers(skill) {
+		if r.State() != StateRunning {
+			continue
+		}
+		running++
+		if !r.queue.full() {
+			return nil
+		}
+	}
+	if running > 0 {
+		return fmt.Errorf("%w for skill %q", ErrQueueFull, skill)
+	}
+	return nil
+}
+
+// RouteTask executes the task on the named agent after checking that the
+// agent declares the requested skill.
+func (m *Manager) RouteTask(ctx context.Context, agentName string, req *pb.TaskRequest) ([]*pb.TaskEvent, error) {
+	var events []*pb.TaskEvent
+	err := m.RouteTaskStream(ctx, agentName, req, collect(&events))
+	return events, err
+}
+
+// RouteTaskStream is RouteTask with events delivered to fn as they arrive.
+// Failures before the first event are retried per the skill's retry policy.
+// Skills with a cache policy are answered from the result cache when
+// possible.
+func (m *Manager) RouteTaskStream(ctx context.Context, agentName string, req *pb.TaskRequest, fn func(*pb.TaskEvent) error) error {
+	_, err := m.cached(ctx, agentName, req, fn, func(fn func(*pb.TaskEvent) error) (string, error) {
+		return agentName, m.routeTaskStream(ctx, agentName, req, fn)
+	})
+	return err
+}
+
+func (m *Manager) routeTaskStream(ctx context.Context, agentName string, req *pb.TaskRequest, fn func(*pb.TaskEvent) error) error {
+	// Retries run within the requested timeout, not each for its length.
+	ctx, cancel := withRequestedTimeout(ctx)
+	defer cancel()
+	return m.retrier.do(ctx, req.Skill, req.TaskId, func() (bool, error) {
+		// Look the runner up on every attempt: config changes replace it.
+		if err := m.CheckRoute(agentName, req.Skill); err != nil {
+			notifyAttempt(ctx, Attempt{Agent: agentName, Started: time.Now(), Err: err})
+			return false, err
+		}
+		runner, _ := m.Runner(agentName)
+		delivered := false
+		err := runner.ExecuteStream(ctx, req, func(ev *pb.TaskEvent) error {
+			delivered = true
+			return fn(ev)
+		})
+		return delivered, err
+	})
+}
+
+// RouteSkill executes the task on an agent that provides req.Skill and
+// returns the name of the agent that produced the events. See RouteSkillStream.
+func (m *Manager) RouteSkill(ctx context.Context, req *pb.TaskRequest) (string, []*pb.TaskEvent, error) {
+	var events []*pb.TaskEvent
+	agentName, err := m.RouteSkillStream(ctx, req, collect(&events))
+	return agentName, events, err
+}
+
+// RouteSkillStream executes the task on an agent that provides req.Skill,
+// delivering events to fn as they arrive. The providers are ordered by the
+// skill's routing strategy; agents that are not running are skipped, and if
+// an agent fails before producing any event the next one is tried. Once an
+// event has been delivered the task is committed to that agent. If every
+// provider fails, the whole round is retried per the skill's retry policy.
+// Skills with a cache policy are answered from the result cache when
+// possible.
+func (m *Manager) RouteSkillStream(ctx context.Context, req *pb.TaskRequest, fn func(*pb.TaskEvent) error) (string, error) {
+	return m.cached(ctx, "", req, fn, func(fn func(*pb.TaskEvent) error) (string, error) {
+		return m.routeSkillStream(ctx, req, fn)
+	})
+}
+
+func (m *Manager) routeSkillStream(ctx context.Context, req *pb.TaskRequest, fn func(*pb.TaskEvent) error) (string, error) {
+	ctx, cancel := withRequestedTimeout(ctx)
+	defer cancel()
+	var agentName string
+	err := m.retrier.do(ctx, req.Skill, req.TaskId, func() (bool, error) {
+		var delivered bool
+		var err error
+		agentName, delivered, err = m.routeSkillOnce(ctx, req, fn)
+		return delivered, err
+	})
+	return agentName, err
+}
+
+// routeSkillOnce tries the providers of req.Skill once each, in routing order.
+func (m *Manager) routeSkillOnce(ctx context.Context, req *pb.TaskRequest, fn func(*pb.TaskEvent) error) (string, bool, error) {
+	candidates := m.providers(req.Skill)
+	if len(candidates) == 0 {
+		return "", false, fmt.Errorf("%w %q", ErrUnknownSkill, req.Skill)
+	}
+
+	var errs []error
+	for _, r := range m.router.order(req.Skill, candidates) {
+		if r.State() != StateRunning {
+			continue
+		}
+		delivered := false
+		err := r.ExecuteStream(ctx, req, func(ev *pb.TaskEvent) error {
+			delivered = true
+			return fn(ev)
+		})
+		if err == nil || delivered {
+			return r.Name(), delivered, err
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil || errors.Is(err, ErrTimeout) {
+			break // the caller is gone or the deadline passed, don't fail over
+		}
+		slog.Warn("task failed, trying next provider",
+			"skill", req.Skill, "agent", r.Name(), "task_id", req.TaskId, "error", err)
+	}
+
+	if len(errs) == 0 {
+		err := fmt.Errorf("%w %q", ErrNoRunningAgent, req.Skill)
+		notifyAttempt(ctx, Attempt{Started: time.Now(), Err: err})
+		return "", false, err
+	}
+	return "", false, errors.Join(errs...)
+}
+
+// CancelTask asks the agent executing taskID to stop it and returns the
+// agent's name. Returns ErrUnknownTask if no agent is executing the task and
+// ErrCancelUnsupported if the agent does not implement the Cancel RPC; the
+// caller should then drop the task's stream instead.
+func (m *Manager) CancelTask(ctx context.Context, taskID string) (string, error) {
+	m.mu.RLock()
+	var runner *Runner
+	for _, r := range m.runners {
+		if r.Executing(taskID) {
+			runner = r
+			break
+		}
+	}
+	m.mu.RUnlock()
+
+	if runner == nil {
+		return "", fmt.Errorf("%w: %s", ErrUnknownTask, taskID)
+	}
+	return runner.Name(), runner.Cancel(ctx, taskID)
+}
+
+// providers returns the runners of every agent that provides skill, in
+// discovery order.
+func (m *Manager) providers(skill string) []*Runner {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	names := m.registry.AgentsForSkill(skill)
+	runners := make([]*Runner, 0, len(names))
+	for _, name := range names {
+		if r, ok := m.runners[name]; ok {
+			runners = append(runners, r)
+		}
+	}
+	return runners
+}
+
+// SkillInfo describes a skill, its routing strategy and its providers.
+type SkillInfo struct {
+	Skill    string   `json:"skill"`
+	Strategy Strategy `json:"strategy"`
+	Agents   []string `json:"agents"` // in priority order
+}
+
+// Skills lists every registered skill, sorted by name.
+func (m *Manager) Skills() []SkillInfo {
+	skills := m.registry.Skills()
+	out := make([]SkillInfo, 0, len(skills))
+	for _, skill := range skills {
+		info := SkillInfo{Skill: skill, Strategy: m.router.strategy(skill)}
+		providers := m.providers(skill)
+		sort.SliceStable(providers, func(i, j int) bool {
+			return providers[i].Manifest().Priority > providers[j].Manifest().Priority
+		})
+		for _, r := range providers {
+			info.Agents = append(info.Agents, r.Name())
+		}
+		out = append(out, info)
+	}
+	return out
 }
 
 // AllStatuses returns the status of every registered agent.
@@ -1,10 +1,14 @@
 package agent
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"time"
 )
 
 // Manifest describes an agent loaded from its manifest.json.
@@ -15,6 +19,123 @@ type Manifest struct {
 	Command     string   `json:"command"`
 	Args        []string `json:"args,omitempty"`
 	Dir         string   `json:"dir"` // working directory relative to project root
+
+	Env map[string]string `json:"env,omitempty"` // added to the inherited environment
+
+	// Routing hints used when several agents provide the same skill.
+	Priority int `json:"priority,omitempty"` // higher is tried first (priority strategy)
+	Weight   int `json:"weight,omitempty"`   // relative share (weighted strategy), default 1
+
+	// Admission control. Tasks beyond MaxConcurrency wait in a priority
+	// queue of at most MaxQueue tasks; further tasks are rejected.
+	MaxConcurrency int `json:"max_concurrency,omitempty"` // 0 means unlimited
+	MaxQueue       int `json:"max_queue,omitempty"`       // default 100
+
+	Timeouts map[string]SkillTimeout `json:"timeouts,omitempty"` // skill → task deadline limits
+
+	// NoCache lists skills whose results vary between calls, so they are
+	// never cached even if config.json enables caching for the skill.
+	NoCache []string `json:"no_cache,omitempty"`
+
+	Restart     RestartConfig `json:"restart,omitempty"`
+	StopTimeout Duration      `json:"stop_timeout,omitempty"` // SIGTERM grace period before SIGKILL, default 10s
+}
+
+// SkillTimeout bounds how long a task of one skill may take on the agent,
+// including time spent in its queue. Zero values mean no limit.
+type SkillTimeout struct {
+	Default Duration `json:"default,omitempty"` // used when the task sets no timeout
+	Max     Duration `json:"max,omitempty"`     // caps the requested timeout
+}
+
+// taskTimeout returns the deadline for a task of skill: the requested
+// timeout, or the skill's default, capped at the skill's maximum. Zero
+// means no deadline.
+func (m Manifest) taskTimeout(skill string, requested time.Duration) time.Duration {
+	limits := m.Timeouts[skill]
+	d := requested
+	if d <= 0 {
+		d = limits.Default.Std()
+	}
+	if max := limits.Max.Std(); max > 0 && (d <= 0 || d > max) {
+		d = max
+	}
+	return d
+}
+
+// RestartPolicy decides whether an agent is relaunched after it exits.
+type RestartPolicy string
+
+const (
+	RestartAlways    RestartPolicy = "always"     // restart on any exit, including code 0
+	RestartOnFailure RestartPolicy = "on-failure" // restart on crashes and failed health checks
+	RestartNever     RestartPolicy = "never"      // leave the agent failed
+)
+
+// RestartConfig controls automatic restarts with exponential backoff.
+// Zero values are replaced by the defaults in withDefaults.
+type RestartConfig struct {
+	Policy      RestartPolicy `json:"policy,omitempty"`       // default on-failure
+	MaxRestarts int           `json:"max_restarts,omitempty"` // crash-loop limit within Window
+	Window      Duration      `json:"window,omitempty"`
+	Backoff     Duration      `json:"backoff,omitempty"` // delay before the first restart
+	MaxBackoff  Duration      `json:"max_backoff,omitempty"`
+}
+
+func (c RestartConfig) withDefaults() RestartConfig {
+	if c.Policy == "" {
+		c.Policy = RestartOnFailure
+	}
+	if c.MaxRestarts <= 0 {
+		c.MaxRestarts = 5
+	}
+	if c.Window <= 0 {
+		c.Window = Duration(10 * time.Minute)
+	}
+	if c.Backoff <= 0 {
+		c.Backoff = Duration(time.Second)
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = Duration(time.Minute)
+	}
+	return c
+}
+
+// delay returns the backoff before restart number n (0-based), doubling
+// each time up to MaxBackoff.
+func (c RestartConfig) delay(n int) time.Duration {
+	d := c.Backoff.Std()
+	for i := 0; i < n && d < c.MaxBackoff.Std(); i++ {
+		d *= 2
+	}
+	if d > c.MaxBackoff.Std() {
+		d = c.MaxBackoff.Std()
+	}
+	return d
+}
+
+// Duration is a time.Duration that reads and writes JSON as a Go duration
+// string such as "500ms" or "2m".
+type Duration time.Duration
+
+// Std returns d as a time.Duration.
+func (d Duration) Std() time.Duration { return time.Duration(d) }
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
 }
 
 // LoadManifest reads and validates a manifest.json file.
@@ -47,9 +168,101 @@ func (m Manifest) Validate() error {
 	if m.Command == "" {
 		return fmt.Errorf("command is required")
 	}
+	if m.Weight < 0 {
+		return fmt.Errorf("weight must not be negative")
+	}
+	if m.MaxConcurrency < 0 {
+		return fmt.Errorf("max_concurrency must not be negative")
+	}
+	if m.MaxQueue < 0 {
+		return fmt.Errorf("max_queue must not be negative")
+	}
+	for skill, t := range m.Timeouts {
+		if !slices.Contains(m.Skills, skill) {
+			return fmt.Errorf("timeouts: %q is not a skill of this agent", skill)
+		}
+		if t.Default < 0 || t.Max < 0 {
+			return fmt.Errorf("timeouts: %s: durations must not be negative", skill)
+		}
+		if t.Max > 0 && t.Default > t.Max {
+			return fmt.Errorf("timeouts: %s: default exceeds max", skill)
+		}
+	}
+	for _, skill := range m.NoCache {
+		if !slices.Contains(m.Skills, skill) {
+			return fmt.Errorf("no_cache: %q is not a skill of this agent", skill)
+		}
+	}
+	switch m.Restart.Policy {
+	case "", RestartAlways, RestartOnFailure, RestartNever:
+	default:
+		return fmt.Errorf("unknown restart policy %q", m.Restart.Policy)
+	}
 	return nil
 }
 
+// WithOverrides returns a copy of m with the manifest fields in raw (a JSON
+// object, typically from config.json) applied on top. Name and skills cannot
+// be overridden, and unknown fields are rejected.
+func (m Manifest) WithOverrides(raw json.RawMessage) (Manifest, error) {
+	if len(bytes.TrimSpace(raw)) == 0 || bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+		return m, nil
+	}
+
+	// Round-trip through JSON so slices and maps are not shared with m.
+	data, err := json.Marshal(m)
+	if err != nil {
+		return m, err
+	}
+	var out Manifest
+	if err := json.Unmarshal(data, &out); err != nil {
+		return m, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&out); err != nil {
+		return m, fmt.Errorf("overrides: %w", err)
+	}
+	if out.Name != m.Name {
+		return m, fmt.Errorf("overrides: name cannot be changed")
+	}
+	if !slices.Equal(out.Skills, m.Skills) {
+		return m, fmt.Errorf("overrides: skills cannot be changed")
+	}
+	if err := out.Validate(); err != nil {
+		return m, fmt.Errorf("overrides: %w", err)
+	}
+	return out, nil
+}
+
+// environ returns the process environment for the agent: the inherited
+// environment plus the manifest's env entries, or nil if there are none.
+func (m Manifest) environ() []string {
+	if len(m.Env) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m.Env))
+	for k := range m.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	env := os.Environ()
+	for _, k := range keys {
+		env = append(env, k+"="+m.Env[k])
+	}
+	return env
+}
+
+// stopTimeout returns the grace period between SIGTERM and SIGKILL.
+func (m Manifest) stopTimeout() time.Duration {
+	if m.StopTimeout <= 0 {
+		return 10 * time.Second
+	}
+	return m.StopTimeout.Std()
+}
+
 // AbsDir resolves the working directory relative to a base path.
 func (m Manifest) AbsDir(base string) string {
 	if filepath.IsAbs(m.Dir) {
@@ -49,6 +49,12 @@ func (c *AgentClient) Health(ctx context.Context) (*HealthResponse, error) {
 	return resp, nil
 }
 
+// Cancel calls the Cancel RPC for the given task.
+func (c *AgentClient) Cancel(ctx context.Context, taskID string) error {
+	return c.cc.Invoke(ctx, "/agent.AgentService/Cancel", &CancelRequest{TaskId: taskID}, &Empty{},
+		grpc.ForceCodec(Codec{}))
+}
+
 // Close closes the underlying connection.
 func (c *AgentClient) Close() error {
 	return c.cc.Close()
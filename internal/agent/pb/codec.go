@@ -21,6 +21,8 @@ func (Codec) Marshal(v any) ([]byte, error) {
 		return marshalTaskEvent(m), nil
 	case *HealthResponse:
 		return marshalHealthResponse(m), nil
+	case *CancelRequest:
+		return marshalCancelRequest(m), nil
 	case *Empty:
 		return nil, nil
 	default:
@@ -36,6 +38,8 @@ func (Codec) Unmarshal(data []byte, v any) error {
 		return unmarshalTaskEvent(data, m)
 	case *HealthResponse:
 		return unmarshalHealthResponse(data, m)
+	case *CancelRequest:
+		return unmarshalCancelRequest(data, m)
 	case *Empty:
 		return nil
 	default:
@@ -183,6 +187,40 @@ func unmarshalHealthResponse(data []byte, m *HealthResponse) error {
 	return nil
 }
 
+// --- CancelRequest: task_id=1 ---
+
+func marshalCancelRequest(m *CancelRequest) []byte {
+	return appendString(nil, 1, m.TaskId)
+}
+
+func unmarshalCancelRequest(data []byte, m *CancelRequest) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("invalid tag")
+		}
+		data = data[n:]
+		switch typ {
+		case protowire.BytesType:
+			val, n := protowire.ConsumeBytes(data)
+			if n < 0 {
+				return fmt.Errorf("invalid bytes for field %d", num)
+			}
+			data = data[n:]
+			if num == 1 {
+				m.TaskId = string(val)
+			}
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return fmt.Errorf("invalid field %d", num)
+			}
+			data = data[n:]
+		}
+	}
+	return nil
+}
+
 // --- helpers ---
 
 func appendString(b []byte, fieldNum protowire.Number, s string) []byte {
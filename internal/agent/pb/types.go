@@ -15,10 +15,15 @@ type TaskRequest struct {
 // TaskEvent is streamed back from the agent during execution.
 type TaskEvent struct {
 	TaskId  string `json:"task_id"`
-	Type    string `json:"type"`    // "progress", "result", "error"
+	Type    string `json:"type"` // "progress", "result", "error", "cancelled"; Idra adds "timeout" when the deadline passes
 	Payload string `json:"payload"`
 }
 
+// CancelRequest asks an agent to stop a task it is executing.
+type CancelRequest struct {
+	TaskId string `json:"task_id"`
+}
+
 // HealthResponse is returned by the agent health check.
 type HealthResponse struct {
 	Status    string `json:"status"`
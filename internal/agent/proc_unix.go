@@ -0,0 +1,51 @@
+//go:build linux || darwin
+
+package agent
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// procAttr starts the agent as the leader of a new process group so the
+// whole group (including forked workers) can be signalled at once.
+func procAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminateGroup asks every process in the group led by pid to exit.
+func terminateGroup(pid int) error {
+	return signalGroup(pid, syscall.SIGTERM)
+}
+
+// killGroup forcibly kills every process in the group led by pid.
+func killGroup(pid int) error {
+	return signalGroup(pid, syscall.SIGKILL)
+}
+
+// groupAlive reports whether any process is left in the group led by pid.
+// While one is, the group ID cannot be reused, so signalling it is safe even
+// after the leader has been reaped.
+func groupAlive(pid int) bool {
+	err := syscall.Kill(-pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+func signalGroup(pid int, sig syscall.Signal) error {
+	err := syscall.Kill(-pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil // group already gone
+	}
+	return err
+}
+
+// exitSignal returns the name of the signal that terminated the process,
+// or "" if it exited normally.
+func exitSignal(ps *os.ProcessState) string {
+	ws, ok := ps.Sys().(syscall.WaitStatus)
+	if !ok || !ws.Signaled() {
+		return ""
+	}
+	return ws.Signal().String()
+}
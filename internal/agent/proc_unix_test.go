@@ -0,0 +1,100 @@
+//go:build linux || darwin
+
+package agent
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// startGroup runs script with sh as the leader of a new process group and
+// returns its PID and a channel closed once the leader has been reaped.
+func startGroup(t *testing.T, script string) (int, <-chan struct{}) {
+	t.Helper()
+	cmd := exec.Command("sh", "-c", script)
+	cmd.SysProcAttr = procAttr()
+	if err := cmd.Start(); err != nil {
+		t.Skip("no sh:", err)
+	}
+	pid := cmd.Process.Pid
+	t.Cleanup(func() { killGroup(pid) })
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(done)
+	}()
+	// Let sh install its traps and fork its workers.
+	time.Sleep(200 * time.Millisecond)
+	return pid, done
+}
+
+// waitGroupGone waits for the group's last members to be reaped: processes
+// killed after their parent exited linger as zombies until init gets to them.
+func waitGroupGone(t *testing.T, pid int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); groupAlive(pid); time.Sleep(20 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("process group %d still has members", pid)
+		}
+	}
+}
+
+func TestTerminateGroup(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		maxTime  time.Duration // how long terminate may take
+		minTime  time.Duration // how long it must wait for stragglers
+		stopWait Duration
+	}{
+		{
+			name:     "leader and worker exit on SIGTERM",
+			script:   `sleep 30 & wait`,
+			maxTime:  4 * time.Second,
+			stopWait: Duration(5 * time.Second),
+		},
+		{
+			name:     "worker ignores SIGTERM after the leader exits",
+			script:   `sh -c 'trap "" TERM; sleep 30' & trap 'exit 0' TERM; while :; do sleep 0.05; done`,
+			minTime:  400 * time.Millisecond,
+			maxTime:  3 * time.Second,
+			stopWait: Duration(500 * time.Millisecond),
+		},
+		{
+			name:     "leader ignores SIGTERM",
+			script:   `trap "" TERM; sleep 30 & while :; do sleep 0.05; done`,
+			minTime:  400 * time.Millisecond,
+			maxTime:  3 * time.Second,
+			stopWait: Duration(500 * time.Millisecond),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pid, done := startGroup(t, tt.script)
+			r := NewRunner(Manifest{Name: "test", StopTimeout: tt.stopWait}, "")
+
+			start := time.Now()
+			r.terminate(pid, done)
+			took := time.Since(start)
+
+			waitGroupGone(t, pid)
+			if took < tt.minTime || took > tt.maxTime {
+				t.Errorf("terminate took %v, want between %v and %v", took, tt.minTime, tt.maxTime)
+			}
+		})
+	}
+}
+
+func TestDrainGroupAfterLeaderCrash(t *testing.T) {
+	// The leader exits on its own, leaving a worker that ignores SIGTERM.
+	pid, done := startGroup(t, `sh -c 'trap "" TERM; sleep 30' & sleep 0.1; exit 1`)
+	<-done
+	if !groupAlive(pid) {
+		t.Fatal("worker exited with the leader; nothing to test")
+	}
+	r := NewRunner(Manifest{Name: "test"}, "")
+	terminateGroup(pid)
+	r.drainGroup(pid, time.Now().Add(300*time.Millisecond))
+	waitGroupGone(t, pid)
+}
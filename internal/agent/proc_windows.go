@@ -0,0 +1,39 @@
+//go:build windows
+
+package agent
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// procAttr starts the agent in a new process group so that it does not
+// receive console signals meant for idra itself.
+func procAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// terminateGroup is not supported on Windows: there is no SIGTERM for
+// console-less processes, so callers fall through to killGroup.
+func terminateGroup(pid int) error {
+	return errors.New("graceful termination not supported on windows")
+}
+
+// killGroup forcibly kills the process tree rooted at pid.
+func killGroup(pid int) error {
+	return exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid)).Run()
+}
+
+// groupAlive always reports false on Windows: killGroup takes the whole tree
+// down with the agent, and a tree whose root has exited cannot be found.
+func groupAlive(pid int) bool {
+	return false
+}
+
+// exitSignal always returns "" on Windows, where processes have no signals.
+func exitSignal(ps *os.ProcessState) string {
+	return ""
+}
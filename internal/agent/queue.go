@@ -0,0 +1,157 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultMaxQueue is the queue length used when a manifest limits
+// concurrency but does not set max_queue.
+const defaultMaxQueue = 100
+
+// Priority is the scheduling class of a task, taken from the "priority"
+// metadata key. Higher classes leave the queue first; tasks within a class
+// run in arrival order.
+type Priority int
+
+const (
+	PriorityLow Priority = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+// ParsePriority reads a priority class name: "high", "normal" or "low". The
+// empty string means normal.
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "", "normal":
+		return PriorityNormal, nil
+	case "high":
+		return PriorityHigh, nil
+	case "low":
+		return PriorityLow, nil
+	default:
+		return PriorityNormal, fmt.Errorf("%w %q (want high, normal or low)", ErrInvalidPriority, s)
+	}
+}
+
+// queue admits at most limit concurrent tasks and holds up to max more,
+// released by priority.
+type queue struct {
+	mu      sync.Mutex
+	limit   int // 0 means unlimited
+	max     int
+	active  int
+	waiting [PriorityHigh + 1][]*waiter
+	depth   int
+
+	avgWait time.Duration // moving average of time spent waiting
+}
+
+type waiter struct {
+	ready    chan struct{} // closed when the waiter is given a slot
+	enqueued time.Time
+}
+
+func newQueue(m Manifest) *queue {
+	q := &queue{limit: m.MaxConcurrency, max: m.MaxQueue}
+	if q.max == 0 {
+		q.max = defaultMaxQueue
+	}
+	return q
+}
+
+// acquire waits for an execution slot. The returned release function must
+// be called when the task is done. Returns ErrQueueFull if the queue is at
+// capacity and ctx.Err() if ctx ends while waiting.
+func (q *queue) acquire(ctx context.Context, p Priority) (release func(), err error) {
+	q.mu.Lock()
+	if q.limit == 0 || (q.active < q.limit && q.depth == 0) {
+		q.active++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+	if q.depth >= q.max {
+		q.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	w := &waiter{ready: make(chan struct{}), enqueued: time.Now()}
+	q.waiting[p] = append(q.waiting[p], w)
+	q.depth++
+	q.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return q.release, nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		select {
+		case <-w.ready:
+			// Given a slot just as ctx ended; hand it on.
+			q.active--
+			q.dispatch()
+		default:
+			q.remove(p, w)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+func (q *queue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.active--
+	q.dispatch()
+}
+
+// full reports whether a new task would be rejected.
+func (q *queue) full() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.limit > 0 && q.active >= q.limit && q.depth >= q.max
+}
+
+// stats returns the number of waiting tasks and the average wait.
+func (q *queue) stats() (depth int, avgWait time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.depth, q.avgWait
+}
+
+// dispatch hands free slots to the highest-priority waiters. Caller must
+// hold mu.
+func (q *queue) dispatch() {
+	for p := PriorityHigh; p >= PriorityLow && q.active < q.limit; {
+		if len(q.waiting[p]) == 0 {
+			p--
+			continue
+		}
+		w := q.waiting[p][0]
+		q.waiting[p] = q.waiting[p][1:]
+		q.depth--
+		q.active++
+
+		// Exponential moving average, weighting the newest wait by 1/5.
+		wait := time.Since(w.enqueued)
+		if q.avgWait == 0 {
+			q.avgWait = wait
+		} else {
+			q.avgWait += (wait - q.avgWait) / 5
+		}
+		close(w.ready)
+	}
+}
+
+// remove drops a waiter whose context ended. Caller must hold mu.
+func (q *queue) remove(p Priority, w *waiter) {
+	for i, x := range q.waiting[p] {
+		if x == w {
+			q.waiting[p] = append(q.waiting[p][:i], q.waiting[p][i+1:]...)
+			q.depth--
+			return
+		}
+	}
+}
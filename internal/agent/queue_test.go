@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParsePriority(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Priority
+		wantErr bool
+	}{
+		{"", PriorityNormal, false},
+		{"normal", PriorityNormal, false},
+		{"high", PriorityHigh, false},
+		{"low", PriorityLow, false},
+		{"urgent", PriorityNormal, true},
+		{"HIGH", PriorityNormal, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParsePriority(tt.in)
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("ParsePriority(%q) = %v, %v; want %v, wantErr %v", tt.in, got, err, tt.want, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidPriority) {
+				t.Errorf("error %v is not ErrInvalidPriority", err)
+			}
+		})
+	}
+}
+
+// waitDepth waits until q holds n waiting tasks.
+func waitDepth(t *testing.T, q *queue, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if d, _ := q.stats(); d == n {
+			return
+		}
+	}
+	t.Fatalf("queue depth never reached %d", n)
+}
+
+func TestQueueAdmission(t *testing.T) {
+	tests := []struct {
+		name     string
+		limit    int
+		max      int
+		running  int   // tasks holding a slot
+		waiting  int   // tasks queued behind them
+		want     error // for one more task; context.DeadlineExceeded = queued
+		wantFull bool
+	}{
+		{"unlimited", 0, 0, 5, 0, nil, false},
+		{"free slot", 2, 1, 1, 0, nil, false},
+		{"queued behind a busy agent", 1, 2, 1, 0, context.DeadlineExceeded, false},
+		{"queued while others wait", 1, 2, 1, 1, context.DeadlineExceeded, false},
+		{"rejected when full", 1, 2, 1, 2, ErrQueueFull, true},
+		{"default max queue", 1, 0, 1, defaultMaxQueue, ErrQueueFull, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQueue(Manifest{MaxConcurrency: tt.limit, MaxQueue: tt.max})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			for range tt.running {
+				if _, err := q.acquire(ctx, PriorityNormal); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for range tt.waiting {
+				go q.acquire(ctx, PriorityNormal)
+			}
+			waitDepth(t, q, tt.waiting)
+
+			if got := q.full(); got != tt.wantFull {
+				t.Errorf("full() = %v, want %v", got, tt.wantFull)
+			}
+			short, stop := context.WithTimeout(ctx, 20*time.Millisecond)
+			defer stop()
+			release, err := q.acquire(short, PriorityHigh)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("acquire() error = %v, want %v", err, tt.want)
+			}
+			if err == nil {
+				release()
+			}
+			// A task that gave up waiting leaves the queue.
+			waitDepth(t, q, tt.waiting)
+		})
+	}
+}
+
+func TestQueueReleasesByPriority(t *testing.T) {
+	q := newQueue(Manifest{MaxConcurrency: 1})
+	release, err := q.acquire(context.Background(), PriorityNormal)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		mu    sync.Mutex
+		order []string
+		wg    sync.WaitGroup
+	)
+	arrivals := []struct {
+		name string
+		p    Priority
+	}{
+		{"low", PriorityLow},
+		{"normal-1", PriorityNormal},
+		{"high-1", PriorityHigh},
+		{"normal-2", PriorityNormal},
+		{"high-2", PriorityHigh},
+	}
+	for i, a := range arrivals {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rel, err := q.acquire(context.Background(), a.p)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			mu.Lock()
+			order = append(order, a.name)
+			mu.Unlock()
+			rel()
+		}()
+		waitDepth(t, q, i+1)
+	}
+	release()
+	wg.Wait()
+
+	want := []string{"high-1", "high-2", "normal-1", "normal-2", "low"}
+	if len(order) != len(want) {
+		t.Fatalf("ran %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("ran %v, want %v", order, want)
+		}
+	}
+}
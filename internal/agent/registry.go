@@ -5,13 +5,14 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
-// Registry holds discovered agent manifests and a skill→agent lookup map.
+// Registry holds discovered agent manifests and a skill→agents lookup map.
 type Registry struct {
 	agents   []Manifest
-	skillMap map[string]string // skill name → agent name
-	baseDir  string           // project root for resolving relative paths
+	skillMap map[string][]string // skill name → agent names, in discovery order
+	baseDir  string              // project root for resolving relative paths
 }
 
 // NewRegistry creates a registry by scanning the given agents directory
@@ -19,7 +20,7 @@ type Registry struct {
 func NewRegistry(agentsDir string) (*Registry, error) {
 	baseDir := filepath.Dir(agentsDir) // project root is parent of agents/
 	r := &Registry{
-		skillMap: make(map[string]string),
+		skillMap: make(map[string][]string),
 		baseDir:  baseDir,
 	}
 
@@ -47,14 +48,9 @@ func NewRegistry(agentsDir string) (*Registry, error) {
 			continue
 		}
 
-		// Register skills
+		// Register skills; several agents may provide the same skill
 		for _, skill := range m.Skills {
-			if existing, ok := r.skillMap[skill]; ok {
-				slog.Warn("skill conflict, keeping first agent",
-					"skill", skill, "kept", existing, "skipped", m.Name)
-				continue
-			}
-			r.skillMap[skill] = m.Name
+			r.skillMap[skill] = append(r.skillMap[skill], m.Name)
 		}
 
 		r.agents = append(r.agents, m)
@@ -69,10 +65,29 @@ func (r *Registry) Agents() []Manifest {
 	return r.agents
 }
 
-// AgentForSkill returns the agent name that handles the given skill.
+// AgentForSkill returns the first agent discovered for the given skill.
 func (r *Registry) AgentForSkill(skill string) (string, bool) {
-	name, ok := r.skillMap[skill]
-	return name, ok
+	names := r.skillMap[skill]
+	if len(names) == 0 {
+		return "", false
+	}
+	return names[0], true
+}
+
+// AgentsForSkill returns every agent that provides the given skill, in
+// discovery order.
+func (r *Registry) AgentsForSkill(skill string) []string {
+	return r.skillMap[skill]
+}
+
+// Skills returns every registered skill name, sorted.
+func (r *Registry) Skills() []string {
+	skills := make([]string, 0, len(r.skillMap))
+	for skill := range r.skillMap {
+		skills = append(skills, skill)
+	}
+	sort.Strings(skills)
+	return skills
 }
 
 // BaseDir returns the project root directory.
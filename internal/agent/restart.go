@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// fail marks the agent as failed, kills its process and applies the
+// restart policy. Used when a running agent stops answering health checks.
+func (r *Runner) fail(err error) {
+	r.setFailed(err)
+	r.teardown()
+	r.scheduleRestart(false)
+}
+
+// scheduleRestart relaunches a failed agent after an exponential backoff,
+// according to the manifest's restart policy. cleanExit reports whether the
+// process exited with code 0. If the agent has already been restarted
+// MaxRestarts times within the window it is left failed (crash loop).
+func (r *Runner) scheduleRestart(cleanExit bool) {
+	rc := r.manifest.Restart.withDefaults()
+	switch rc.Policy {
+	case RestartNever:
+		return
+	case RestartOnFailure:
+		if cleanExit {
+			return
+		}
+	}
+
+	r.mu.Lock()
+	if r.state != StateFailed || r.parentCtx == nil || r.parentCtx.Err() != nil {
+		r.mu.Unlock()
+		return
+	}
+
+	now := time.Now()
+	recent := r.restartLog[:0]
+	for _, t := range r.restartLog {
+		if now.Sub(t) < rc.Window.Std() {
+			recent = append(recent, t)
+		}
+	}
+	r.restartLog = recent
+
+	if len(recent) >= rc.MaxRestarts {
+		r.err = fmt.Errorf("crash loop: %d restarts within %s, giving up: %w",
+			len(recent), rc.Window.Std(), r.err)
+		r.mu.Unlock()
+		slog.Error("agent restart limit reached", "agent", r.manifest.Name, "error", r.err)
+		return
+	}
+
+	delay := rc.delay(len(recent))
+	parentCtx := r.parentCtx
+	ctx, cancel := context.WithCancel(parentCtx)
+	r.restartCancel = cancel
+	r.state = StateRestarting
+	r.mu.Unlock()
+
+	slog.Warn("scheduling agent restart", "agent", r.manifest.Name,
+		"delay", delay, "attempt", len(recent)+1, "policy", rc.Policy)
+
+	go func() {
+		defer cancel()
+
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return
+		}
+
+		r.mu.Lock()
+		if ctx.Err() != nil || r.state != StateRestarting {
+			r.mu.Unlock()
+			return
+		}
+		r.restartCancel = nil
+		r.restarts++
+		r.lastRestart = time.Now()
+		r.restartLog = append(r.restartLog, r.lastRestart)
+		r.mu.Unlock()
+
+		slog.Info("restarting agent", "agent", r.manifest.Name, "restarts", r.restarts)
+		if err := r.start(parentCtx); err != nil {
+			r.scheduleRestart(false)
+		}
+	}()
+}
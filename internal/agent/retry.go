@@ -0,0 +1,167 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"idra/internal/config"
+)
+
+// ErrorClass names a kind of task failure that a retry policy may retry.
+type ErrorClass string
+
+const (
+	ClassUnavailable       ErrorClass = "unavailable"        // gRPC UNAVAILABLE: agent unreachable or shutting down
+	ClassNotRunning        ErrorClass = "not-running"        // agent stopped, failed or restarting
+	ClassResourceExhausted ErrorClass = "resource-exhausted" // gRPC RESOURCE_EXHAUSTED
+	ClassQueueFull         ErrorClass = "queue-full"         // the agent's task queue is full
+	ClassTimeout           ErrorClass = "timeout"            // the task's deadline passed
+)
+
+// defaultRetryOn is retried when a policy does not list error classes.
+var defaultRetryOn = []ErrorClass{ClassUnavailable, ClassNotRunning}
+
+// Classify returns the error class of a task failure, or "" if it is not
+// one of the known transient classes.
+func Classify(err error) ErrorClass {
+	switch {
+	case errors.Is(err, ErrAgentNotRunning), errors.Is(err, ErrNoRunningAgent):
+		return ClassNotRunning
+	case errors.Is(err, ErrQueueFull):
+		return ClassQueueFull
+	case errors.Is(err, ErrTimeout):
+		return ClassTimeout
+	}
+	switch status.Code(err) {
+	case codes.Unavailable:
+		return ClassUnavailable
+	case codes.ResourceExhausted:
+		return ClassResourceExhausted
+	}
+	return ""
+}
+
+// RetryPolicy retries a task that failed before producing any event.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts including the first
+	Backoff     time.Duration // delay before the first retry, doubled after each
+	MaxBackoff  time.Duration
+	On          []ErrorClass // retryable classes
+}
+
+// ParseRetryPolicy validates a retry policy from config.json and fills in
+// defaults: 3 attempts, 500ms backoff up to 10s, retrying unavailable and
+// not-running errors. A nil config means no retries.
+func ParseRetryPolicy(c *config.RetryConfig) (*RetryPolicy, error) {
+	if c == nil {
+		return nil, nil
+	}
+	p := &RetryPolicy{
+		MaxAttempts: c.MaxAttempts,
+		Backoff:     500 * time.Millisecond,
+		MaxBackoff:  10 * time.Second,
+		On:          defaultRetryOn,
+	}
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = 3
+	}
+	if p.MaxAttempts < 1 {
+		return nil, fmt.Errorf("retry: max_attempts must be at least 1")
+	}
+	if err := parseRetryDuration("backoff", c.Backoff, &p.Backoff); err != nil {
+		return nil, err
+	}
+	if err := parseRetryDuration("max_backoff", c.MaxBackoff, &p.MaxBackoff); err != nil {
+		return nil, err
+	}
+	if len(c.On) > 0 {
+		p.On = nil
+		for _, s := range c.On {
+			switch cl := ErrorClass(s); cl {
+			case ClassUnavailable, ClassNotRunning, ClassResourceExhausted, ClassQueueFull, ClassTimeout:
+				p.On = append(p.On, cl)
+			default:
+				return nil, fmt.Errorf("retry: unknown error class %q", s)
+			}
+		}
+	}
+	return p, nil
+}
+
+// parseRetryDuration parses v into dst unless v is empty.
+func parseRetryDuration(field, v string, dst *time.Duration) error {
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return fmt.Errorf("retry: %s must be a non-negative duration like \"1s\"", field)
+	}
+	*dst = d
+	return nil
+}
+
+// retryable reports whether err is in one of the policy's classes.
+func (p *RetryPolicy) retryable(err error) bool {
+	cl := Classify(err)
+	return cl != "" && slices.Contains(p.On, cl)
+}
+
+// delay returns the backoff before retry number n (1-based).
+func (p *RetryPolicy) delay(n int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, p.MaxBackoff)
+}
+
+// retrier holds the per-skill retry policies.
+type retrier struct {
+	mu       sync.RWMutex
+	policies map[string]*RetryPolicy // skill → policy; missing means no retries
+}
+
+func (rt *retrier) setPolicies(policies map[string]*RetryPolicy) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.policies = policies
+}
+
+func (rt *retrier) policy(skill string) *RetryPolicy {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	return rt.policies[skill]
+}
+
+// do runs attempt until it succeeds, delivers an event (events cannot be
+// replayed), fails with an error the skill's policy does not retry, or the
+// attempts run out. attempt reports whether it delivered any event.
+func (rt *retrier) do(ctx context.Context, skill, taskID string, attempt func() (bool, error)) error {
+	p := rt.policy(skill)
+	for n := 1; ; n++ {
+		delivered, err := attempt()
+		if err == nil || delivered || p == nil || n >= p.MaxAttempts || !p.retryable(err) {
+			return err
+		}
+
+		d := p.delay(n)
+		slog.Warn("task failed, retrying",
+			"skill", skill, "task_id", taskID, "attempt", n, "class", Classify(err), "backoff", d, "error", err)
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"idra/internal/config"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{"not running", fmt.Errorf("%w: echo (state: stopped)", ErrAgentNotRunning), ClassNotRunning},
+		{"no running provider", fmt.Errorf("%w %q", ErrNoRunningAgent, "summarize"), ClassNotRunning},
+		{"queue full", fmt.Errorf("queue on echo: %w", ErrQueueFull), ClassQueueFull},
+		{"timeout", fmt.Errorf("%w: no result from echo within 1s", ErrTimeout), ClassTimeout},
+		{"grpc unavailable", status.Error(codes.Unavailable, "connection refused"), ClassUnavailable},
+		{"grpc resource exhausted", status.Error(codes.ResourceExhausted, "rate limited"), ClassResourceExhausted},
+		{"grpc invalid argument", status.Error(codes.InvalidArgument, "bad input"), ""},
+		{"unknown skill", ErrUnknownSkill, ""},
+		{"invalid priority", fmt.Errorf("%w %q", ErrInvalidPriority, "urgent"), ""},
+		{"plain error", errors.New("boom"), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *config.RetryConfig
+		want    *RetryPolicy
+		wantErr bool
+	}{
+		{"none", nil, nil, false},
+		{"defaults", &config.RetryConfig{}, &RetryPolicy{MaxAttempts: 3, Backoff: 500 * time.Millisecond, MaxBackoff: 10 * time.Second, On: defaultRetryOn}, false},
+		{"custom", &config.RetryConfig{MaxAttempts: 5, Backoff: "1s", MaxBackoff: "1m", On: []string{"queue-full", "timeout"}},
+			&RetryPolicy{MaxAttempts: 5, Backoff: time.Second, MaxBackoff: time.Minute, On: []ErrorClass{ClassQueueFull, ClassTimeout}}, false},
+		{"negative attempts", &config.RetryConfig{MaxAttempts: -1}, nil, true},
+		{"bad backoff", &config.RetryConfig{Backoff: "soon"}, nil, true},
+		{"negative max backoff", &config.RetryConfig{MaxBackoff: "-1s"}, nil, true},
+		{"unknown class", &config.RetryConfig{On: []string{"internal"}}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseRetryPolicy(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRetryPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != (tt.want == nil) {
+				t.Fatalf("ParseRetryPolicy() = %+v, want %+v", got, tt.want)
+			}
+			if got != nil && (got.MaxAttempts != tt.want.MaxAttempts || got.Backoff != tt.want.Backoff ||
+				got.MaxBackoff != tt.want.MaxBackoff || !slices.Equal(got.On, tt.want.On)) {
+				t.Errorf("ParseRetryPolicy() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := &RetryPolicy{Backoff: 500 * time.Millisecond, MaxBackoff: 3 * time.Second}
+	tests := []struct {
+		retry int
+		want  time.Duration
+	}{
+		{1, 500 * time.Millisecond},
+		{2, time.Second},
+		{3, 2 * time.Second},
+		{4, 3 * time.Second},
+		{10, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := p.delay(tt.retry); got != tt.want {
+			t.Errorf("delay(%d) = %v, want %v", tt.retry, got, tt.want)
+		}
+	}
+}
+
+func TestRetrierDo(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+	policy := &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, On: defaultRetryOn}
+	tests := []struct {
+		name         string
+		policy       *RetryPolicy
+		errs         []error // per attempt; the last repeats
+		delivered    bool    // attempts deliver an event before failing
+		wantAttempts int
+		wantErr      error
+	}{
+		{"success", policy, []error{nil}, false, 1, nil},
+		{"retries until success", policy, []error{unavailable, ErrAgentNotRunning, nil}, false, 3, nil},
+		{"gives up after max attempts", policy, []error{unavailable}, false, 3, unavailable},
+		{"no retry for other classes", policy, []error{ErrQueueFull}, false, 1, ErrQueueFull},
+		{"no retry without a policy", nil, []error{unavailable}, false, 1, unavailable},
+		{"no retry after an event", policy, []error{unavailable}, true, 1, unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := &retrier{}
+			rt.setPolicies(map[string]*RetryPolicy{"summarize": tt.policy})
+			n := 0
+			err := rt.do(context.Background(), "summarize", "task-1", func() (bool, error) {
+				err := tt.errs[min(n, len(tt.errs)-1)]
+				n++
+				return tt.delivered, err
+			})
+			if n != tt.wantAttempts || !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("do() = %v after %d attempts, want %v after %d", err, n, tt.wantErr, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+func TestRetrierDoStopsOnCancel(t *testing.T) {
+	rt := &retrier{}
+	rt.setPolicies(map[string]*RetryPolicy{"summarize": {MaxAttempts: 5, Backoff: time.Hour, MaxBackoff: time.Hour, On: defaultRetryOn}})
+	ctx, cancel := context.WithCancel(context.Background())
+	n := 0
+	err := rt.do(ctx, "summarize", "task-1", func() (bool, error) {
+		n++
+		cancel()
+		return false, ErrAgentNotRunning
+	})
+	if n != 1 || !errors.Is(err, ErrAgentNotRunning) {
+		t.Errorf("do() = %v after %d attempts, want the attempt's error after 1", err, n)
+	}
+}
+
+func TestRetrierDoWithinRequestedTimeout(t *testing.T) {
+	rt := &retrier{}
+	rt.setPolicies(map[string]*RetryPolicy{"summarize": {MaxAttempts: 10, Backoff: time.Millisecond, MaxBackoff: time.Millisecond, On: []ErrorClass{ClassTimeout}}})
+	ctx, cancel := withRequestedTimeout(WithTaskTimeout(context.Background(), 200*time.Millisecond))
+	defer cancel()
+
+	// Each attempt times out after the agent's 80ms per-skill maximum.
+	start, n := time.Now(), 0
+	err := rt.do(ctx, "summarize", "task-1", func() (bool, error) {
+		n++
+		attempt, cancel := context.WithTimeout(ctx, 80*time.Millisecond)
+		defer cancel()
+		<-attempt.Done()
+		return false, ErrTimeout
+	})
+	if took := time.Since(start); took > 400*time.Millisecond {
+		t.Errorf("do() took %v, want about the requested 200ms", took)
+	}
+	if n < 2 || n > 3 || !errors.Is(err, ErrTimeout) {
+		t.Errorf("do() = %v after %d attempts, want a timeout after 3", err, n)
+	}
+}
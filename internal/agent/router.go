@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"sync"
+)
+
+// Strategy selects the order in which the providers of a skill are tried.
+// The first agent in the order gets the task; the rest are failover targets.
+type Strategy string
+
+const (
+	StrategyPriority      Strategy = "priority"        // highest manifest priority first (default)
+	StrategyRoundRobin    Strategy = "round-robin"     // rotate the first choice on every task
+	StrategyLeastInFlight Strategy = "least-in-flight" // fewest tasks executing or queued first
+	StrategyWeighted      Strategy = "weighted"        // random, proportional to manifest weight
+)
+
+// ParseStrategy validates a strategy name. The empty string means priority.
+func ParseStrategy(s string) (Strategy, error) {
+	switch Strategy(s) {
+	case "":
+		return StrategyPriority, nil
+	case StrategyPriority, StrategyRoundRobin, StrategyLeastInFlight, StrategyWeighted:
+		return Strategy(s), nil
+	default:
+		return "", fmt.Errorf("unknown routing strategy %q", s)
+	}
+}
+
+// router orders skill providers according to per-skill strategies.
+type router struct {
+	mu         sync.Mutex
+	strategies map[string]Strategy // skill → strategy; missing means priority
+	next       map[string]int      // skill → round-robin position
+}
+
+func newRouter() *router {
+	return &router{
+		strategies: make(map[string]Strategy),
+		next:       make(map[string]int),
+	}
+}
+
+func (rt *router) setStrategies(strategies map[string]Strategy) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.strategies = strategies
+}
+
+func (rt *router) strategy(skill string) Strategy {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if s, ok := rt.strategies[skill]; ok {
+		return s
+	}
+	return StrategyPriority
+}
+
+// order returns the candidates in the order they should be tried. The input
+// is in discovery order and is not modified.
+func (rt *router) order(skill string, candidates []*Runner) []*Runner {
+	out := make([]*Runner, len(candidates))
+	copy(out, candidates)
+
+	// Priority order is the base for every strategy and the tie-breaker.
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Manifest().Priority > out[j].Manifest().Priority
+	})
+
+	switch rt.strategy(skill) {
+	case StrategyRoundRobin:
+		if len(out) > 1 {
+			rt.mu.Lock()
+			n := rt.next[skill] % len(out)
+			rt.next[skill] = n + 1
+			rt.mu.Unlock()
+			out = append(out[n:], out[:n]...)
+		}
+
+	case StrategyLeastInFlight:
+		sort.SliceStable(out, func(i, j int) bool {
+			return out[i].load() < out[j].load()
+		})
+
+	case StrategyWeighted:
+		out = weightedShuffle(out)
+	}
+	return out
+}
+
+// weightedShuffle draws runners without replacement with probability
+// proportional to their manifest weight (default 1).
+func weightedShuffle(runners []*Runner) []*Runner {
+	weight := func(r *Runner) int {
+		if w := r.Manifest().Weight; w > 0 {
+			return w
+		}
+		return 1
+	}
+
+	pool := append([]*Runner(nil), runners...)
+	out := make([]*Runner, 0, len(pool))
+	for len(pool) > 0 {
+		total := 0
+		for _, r := range pool {
+			total += weight(r)
+		}
+		i := 0
+		pick := rand.IntN(total)
+		for ; i < len(pool)-1; i++ {
+			pick -= weight(pool[i])
+			if pick < 0 {
+				break
+			}
+		}
+		out = append(out, pool[i])
+		pool = append(pool[:i], pool[i+1:]...)
+	}
+	return out
+}
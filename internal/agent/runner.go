@@ -1,17 +1,21 @@
 package agent
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os/exec"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 
 	"idra/internal/agent/pb"
 )
@@ -20,24 +24,42 @@ import (
 type State string
 
 const (
-	StateStopped  State = "stopped"
-	StateStarting State = "starting"
-	StateRunning  State = "running"
-	StateFailed   State = "failed"
+	StateStopped    State = "stopped"
+	StateStarting   State = "starting"
+	StateRunning    State = "running"
+	StateFailed     State = "failed"
+	StateRestarting State = "restarting" // waiting out the restart backoff
 )
 
 // Runner manages the lifecycle of a single agent subprocess.
 type Runner struct {
-	manifest Manifest
-	baseDir  string
+	manifest  Manifest
+	baseDir   string
+	logs      *LogBuffer
+	disabled  bool // set from config.json; disabled agents refuse to start
+	inFlight  atomic.Int64
+	queue     *queue      // admission control from max_concurrency
+	executing sync.Map    // task ID → struct{} for tasks currently executing
+	noCancel  atomic.Bool // agent answered Cancel with UNIMPLEMENTED
 
 	mu     sync.RWMutex
 	state  State
 	port   int
+	pid    int
 	client *pb.AgentClient
 	cancel context.CancelFunc
 	done   chan struct{} // closed when process exits
 	err    error
+
+	exited     bool // last process has exited; exitCode/exitSignal are valid
+	exitCode   int
+	exitSignal string
+
+	parentCtx     context.Context    // context passed to Start, reused for restarts
+	restartCancel context.CancelFunc // aborts a pending restart
+	restartLog    []time.Time        // restart times inside the crash-loop window
+	restarts      int
+	lastRestart   time.Time
 }
 
 // NewRunner creates a runner for the given agent manifest.
@@ -45,20 +67,38 @@ func NewRunner(m Manifest, baseDir string) *Runner {
 	return &Runner{
 		manifest: m,
 		baseDir:  baseDir,
+		logs:     NewLogBuffer(m.Name),
+		queue:    newQueue(m),
 		state:    StateStopped,
 	}
 }
 
 // Start spawns the agent subprocess, reads the port handshake, and connects gRPC.
+// An explicit Start also clears the crash-loop history.
 func (r *Runner) Start(parentCtx context.Context) error {
 	r.mu.Lock()
+	r.restartLog = nil
+	r.mu.Unlock()
+	return r.start(parentCtx)
+}
+
+func (r *Runner) start(parentCtx context.Context) error {
+	r.mu.Lock()
+	if r.disabled {
+		r.mu.Unlock()
+		return fmt.Errorf("agent %s is disabled in config", r.manifest.Name)
+	}
 	if r.state == StateRunning || r.state == StateStarting {
 		r.mu.Unlock()
 		return nil
 	}
+	if r.restartCancel != nil {
+		r.restartCancel()
+		r.restartCancel = nil
+	}
 	r.state = StateStarting
 	r.err = nil
-	r.done = make(chan struct{})
+	r.parentCtx = parentCtx
 	r.mu.Unlock()
 
 	ctx, cancel := context.WithCancel(parentCtx)
@@ -66,14 +106,32 @@ func (r *Runner) Start(parentCtx context.Context) error {
 	workDir := r.manifest.AbsDir(r.baseDir)
 	cmd := exec.CommandContext(ctx, r.manifest.Command, r.manifest.Args...)
 	cmd.Dir = workDir
+	cmd.Env = r.manifest.environ()
+	cmd.SysProcAttr = procAttr()
+	// Cancelling the context is the hard stop: kill the whole process group.
+	cmd.Cancel = func() error { return killGroup(cmd.Process.Pid) }
 
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
-		cancel()
-		r.setFailed(fmt.Errorf("stdout pipe: %w", err))
-		return r.err
-	}
-	cmd.Stderr = &logWriter{name: r.manifest.Name}
+	// Both streams are drained for the life of the process into the log
+	// buffer; stdout is also watched for the AGENT_PORT=XXXXX handshake.
+	portCh := make(chan int, 1)
+	stdout := &lineWriter{name: r.manifest.Name, stream: "stdout", buf: r.logs,
+		onLine: func(line string) {
+			if !strings.HasPrefix(line, "AGENT_PORT=") {
+				return
+			}
+			var port int
+			if _, err := fmt.Sscanf(line, "AGENT_PORT=%d", &port); err == nil {
+				select {
+				case portCh <- port:
+				default:
+				}
+			}
+		}}
+	stderr := &lineWriter{name: r.manifest.Name, stream: "stderr", buf: r.logs}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// Don't let a forked child holding the pipes open block cmd.Wait forever.
+	cmd.WaitDelay = 2 * time.Second
 
 	if err := cmd.Start(); err != nil {
 		cancel()
@@ -81,46 +139,36 @@ func (r *Runner) Start(parentCtx context.Context) error {
 		return r.err
 	}
 
+	done := make(chan struct{})
 	r.mu.Lock()
 	r.cancel = cancel
+	r.done = done
+	r.pid = cmd.Process.Pid
+	r.exited = false
 	r.mu.Unlock()
 
 	slog.Info("agent process started", "agent", r.manifest.Name, "pid", cmd.Process.Pid)
 
 	// Start monitor goroutine (the only place that calls cmd.Wait)
-	go r.monitor(cmd)
-
-	// Read AGENT_PORT=XXXXX from stdout (15s timeout)
-	portCh := make(chan int, 1)
-	errCh := make(chan error, 1)
-	go func() {
-		scanner := bufio.NewScanner(stdout)
-		for scanner.Scan() {
-			line := scanner.Text()
-			slog.Debug("agent stdout", "agent", r.manifest.Name, "line", line)
-			if strings.HasPrefix(line, "AGENT_PORT=") {
-				var port int
-				if _, err := fmt.Sscanf(line, "AGENT_PORT=%d", &port); err == nil {
-					portCh <- port
-					return
-				}
-			}
-		}
-		errCh <- fmt.Errorf("agent exited without printing AGENT_PORT")
-	}()
+	go r.monitor(cmd, done, stdout, stderr)
 
+	// Wait for AGENT_PORT=XXXXX on stdout (15s timeout)
 	select {
 	case port := <-portCh:
 		r.mu.Lock()
 		r.port = port
 		r.mu.Unlock()
 		slog.Info("agent port received", "agent", r.manifest.Name, "port", port)
-	case err := <-errCh:
+	case <-done:
+		err := fmt.Errorf("agent exited without printing AGENT_PORT")
 		r.setFailed(err)
-		return r.err
+		r.teardown()
+		return err
 	case <-time.After(15 * time.Second):
-		r.setFailed(fmt.Errorf("timeout waiting for AGENT_PORT"))
-		return r.err
+		err := fmt.Errorf("timeout waiting for AGENT_PORT")
+		r.setFailed(err)
+		r.teardown()
+		return err
 	}
 
 	// Connect gRPC client
@@ -129,8 +177,10 @@ func (r *Runner) Start(parentCtx context.Context) error {
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 	)
 	if err != nil {
-		r.setFailed(fmt.Errorf("grpc connect: %w", err))
-		return r.err
+		err = fmt.Errorf("grpc connect: %w", err)
+		r.setFailed(err)
+		r.teardown()
+		return err
 	}
 
 	r.mu.Lock()
@@ -142,14 +192,29 @@ func (r *Runner) Start(parentCtx context.Context) error {
 	return nil
 }
 
-// Stop gracefully shuts down the agent subprocess.
+// Stop gracefully shuts down the agent subprocess and cancels any pending restart.
 func (r *Runner) Stop() {
 	r.mu.Lock()
-	state := r.state
-	r.state = StateStopped // set before cancel so monitor doesn't mark as Failed
+	r.state = StateStopped // set before signalling so monitor doesn't mark as Failed
+	if r.restartCancel != nil {
+		r.restartCancel()
+		r.restartCancel = nil
+	}
+	r.mu.Unlock()
+
+	r.teardown()
+	slog.Info("agent stopped", "agent", r.manifest.Name)
+}
+
+// teardown closes the gRPC client and terminates the process group: SIGTERM
+// first, then SIGKILL once the manifest's stop_timeout has elapsed. It waits
+// for the monitor goroutine to observe the exit and does not change the state.
+func (r *Runner) teardown() {
+	r.mu.Lock()
 	client := r.client
 	cancel := r.cancel
 	done := r.done
+	pid := r.pid
 	r.client = nil
 	r.cancel = nil
 	r.mu.Unlock()
@@ -157,39 +222,235 @@ func (r *Runner) Stop() {
 	if client != nil {
 		client.Close()
 	}
+	if done != nil && pid != 0 {
+		select {
+		case <-done:
+		default:
+			r.terminate(pid, done)
+		}
+	}
 	if cancel != nil {
-		cancel() // kills the process via CommandContext
+		cancel()
 	}
+}
 
-	// Wait for process to finish (monitor goroutine closes done)
-	if done != nil && (state == StateRunning || state == StateStarting) {
+// terminate sends SIGTERM to the agent's process group and escalates to
+// SIGKILL if the agent, or any worker it forked, has not exited within the
+// stop timeout.
+func (r *Runner) terminate(pid int, done <-chan struct{}) {
+	timeout := r.manifest.stopTimeout()
+	deadline := time.Now().Add(timeout)
+	if err := terminateGroup(pid); err == nil {
 		select {
 		case <-done:
-		case <-time.After(5 * time.Second):
-			slog.Warn("agent stop timed out", "agent", r.manifest.Name)
+			r.drainGroup(pid, deadline)
+			return
+		case <-time.After(timeout):
+			slog.Warn("agent ignored SIGTERM, killing process group",
+				"agent", r.manifest.Name, "timeout", timeout)
 		}
 	}
 
-	slog.Info("agent stopped", "agent", r.manifest.Name)
+	if err := killGroup(pid); err != nil {
+		slog.Warn("kill process group failed", "agent", r.manifest.Name, "error", err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		slog.Warn("agent stop timed out", "agent", r.manifest.Name)
+	}
+}
+
+// drainGroup waits until the process group led by pid is empty, then
+// kills whatever is left at deadline. The leader has already exited; this
+// catches workers that ignored or outlived its SIGTERM.
+func (r *Runner) drainGroup(pid int, deadline time.Time) {
+	for groupAlive(pid) {
+		if time.Now().After(deadline) {
+			slog.Warn("agent workers outlived the agent, killing process group", "agent", r.manifest.Name)
+			if err := killGroup(pid); err != nil {
+				slog.Warn("kill process group failed", "agent", r.manifest.Name, "error", err)
+			}
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
 }
 
 // Execute sends a task to the agent and collects all streamed events.
 func (r *Runner) Execute(ctx context.Context, req *pb.TaskRequest) ([]*pb.TaskEvent, error) {
+	var events []*pb.TaskEvent
+	err := r.ExecuteStream(ctx, req, collect(&events))
+	return events, err
+}
+
+// ExecuteStream sends a task to the agent and calls fn for every event as it
+// arrives. If fn returns an error the stream is abandoned and that error is
+// returned.
+func (r *Runner) ExecuteStream(ctx context.Context, req *pb.TaskRequest, fn func(*pb.TaskEvent) error) (err error) {
+	started := time.Now()
+	defer func() { notifyAttempt(ctx, Attempt{Agent: r.manifest.Name, Started: started, Err: err}) }()
+
 	r.mu.RLock()
 	client := r.client
 	state := r.state
 	r.mu.RUnlock()
 
 	if state != StateRunning || client == nil {
-		return nil, fmt.Errorf("agent %s is not running (state: %s)", r.manifest.Name, state)
+		return fmt.Errorf("%w: %s (state: %s)", ErrAgentNotRunning, r.manifest.Name, state)
 	}
 
-	stream, err := client.Execute(ctx, req)
+	// The deadline covers the queue wait and reaches the agent as the
+	// gRPC deadline.
+	timeout := r.manifest.taskTimeout(req.Skill, requestedTimeout(ctx))
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	prio, err := ParsePriority(req.Metadata["priority"])
+	if err != nil {
+		return err
+	}
+	release, err := r.queue.acquire(ctx, prio)
 	if err != nil {
-		return nil, fmt.Errorf("execute on %s: %w", r.manifest.Name, err)
+		return r.timeoutErr(ctx, timeout, fmt.Errorf("queue on %s: %w", r.manifest.Name, err))
+	}
+	defer release()
+
+	// The agent may have gone down while the task was queued.
+	r.mu.RLock()
+	client = r.client
+	state = r.state
+	r.mu.RUnlock()
+	if state != StateRunning || client == nil {
+		return fmt.Errorf("%w: %s (state: %s)", ErrAgentNotRunning, r.manifest.Name, state)
+	}
+	notifyStart(ctx, r.manifest.Name)
+
+	r.inFlight.Add(1)
+	defer r.inFlight.Add(-1)
+	r.executing.Store(req.TaskId, struct{}{})
+	defer r.executing.Delete(req.TaskId)
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := client.Execute(streamCtx, req)
+	if err != nil {
+		return r.timeoutErr(ctx, timeout, fmt.Errorf("execute on %s: %w", r.manifest.Name, err))
+	}
+
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				// The caller gave up; dropping the stream is all the agent
+				// sees unless it also implements Cancel.
+				go r.notifyCancel(client, req.TaskId)
+			}
+			return r.timeoutErr(ctx, timeout, err)
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+}
+
+// timeoutErr replaces err with ErrTimeout if ctx's deadline has passed.
+func (r *Runner) timeoutErr(ctx context.Context, timeout time.Duration, err error) error {
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return err
+	}
+	if timeout > 0 {
+		return fmt.Errorf("%w: no result from %s within %s", ErrTimeout, r.manifest.Name, timeout)
+	}
+	return fmt.Errorf("%w: no result from %s before the deadline", ErrTimeout, r.manifest.Name)
+}
+
+// Cancel asks the agent to stop a task it is executing. The agent is expected
+// to end the task's stream with a "cancelled" event. Returns
+// ErrCancelUnsupported if the agent does not implement the Cancel RPC and
+// ErrUnknownTask if it is not executing the task.
+func (r *Runner) Cancel(ctx context.Context, taskID string) error {
+	if r.noCancel.Load() {
+		return fmt.Errorf("%w: %s", ErrCancelUnsupported, r.manifest.Name)
+	}
+
+	r.mu.RLock()
+	client := r.client
+	state := r.state
+	r.mu.RUnlock()
+	if state != StateRunning || client == nil {
+		return fmt.Errorf("%w: %s (state: %s)", ErrAgentNotRunning, r.manifest.Name, state)
+	}
+
+	err := client.Cancel(ctx, taskID)
+	switch status.Code(err) {
+	case codes.OK:
+		return nil
+	case codes.Unimplemented:
+		r.noCancel.Store(true)
+		return fmt.Errorf("%w: %s", ErrCancelUnsupported, r.manifest.Name)
+	case codes.NotFound:
+		return fmt.Errorf("%w %s on %s", ErrUnknownTask, taskID, r.manifest.Name)
+	default:
+		return fmt.Errorf("cancel on %s: %w", r.manifest.Name, err)
+	}
+}
+
+// Executing reports whether the task is currently executing on the agent.
+func (r *Runner) Executing(taskID string) bool {
+	_, ok := r.executing.Load(taskID)
+	return ok
+}
+
+// notifyCancel tells the agent about a task whose stream was dropped, so it
+// can stop work nobody is waiting for. Best effort.
+func (r *Runner) notifyCancel(client *pb.AgentClient, taskID string) {
+	if r.noCancel.Load() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := client.Cancel(ctx, taskID)
+	switch status.Code(err) {
+	case codes.OK, codes.NotFound:
+	case codes.Unimplemented:
+		r.noCancel.Store(true)
+	default:
+		slog.Debug("notify agent of cancelled task", "agent", r.manifest.Name, "task_id", taskID, "error", err)
+	}
+}
+
+// collect returns an event callback that appends to events.
+func collect(events *[]*pb.TaskEvent) func(*pb.TaskEvent) error {
+	return func(ev *pb.TaskEvent) error {
+		*events = append(*events, ev)
+		return nil
 	}
+}
+
+// InFlight returns the number of tasks currently executing on the agent.
+func (r *Runner) InFlight() int {
+	return int(r.inFlight.Load())
+}
 
-	return stream.RecvAll()
+// load is the number of tasks executing on or queued for the agent.
+func (r *Runner) load() int {
+	depth, _ := r.queue.stats()
+	return r.InFlight() + depth
+}
+
+// State returns the current lifecycle state.
+func (r *Runner) State() State {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state
 }
 
 // Health pings the agent's Health RPC.
@@ -211,17 +472,58 @@ func (r *Runner) Status() AgentStatus {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 	s := AgentStatus{
-		Name:   r.manifest.Name,
-		State:  string(r.state),
-		Skills: r.manifest.Skills,
-		Port:   r.port,
+		Name:          r.manifest.Name,
+		State:         string(r.state),
+		Skills:        r.manifest.Skills,
+		Port:          r.port,
+		Enabled:       !r.disabled,
+		InFlight:      r.InFlight(),
+		RestartPolicy: string(r.manifest.Restart.withDefaults().Policy),
+		Restarts:      r.restarts,
 	}
+	depth, wait := r.queue.stats()
+	s.MaxConcurrency = r.manifest.MaxConcurrency
+	s.QueueDepth = depth
+	s.QueueWait = Duration(wait)
 	if r.err != nil {
 		s.Error = r.err.Error()
 	}
+	if !r.lastRestart.IsZero() {
+		t := r.lastRestart
+		s.LastRestart = &t
+	}
+	if r.exited && r.state != StateRunning {
+		code := r.exitCode
+		s.ExitCode = &code
+		s.ExitSignal = r.exitSignal
+	}
 	return s
 }
 
+// Manifest returns the effective manifest, including config overrides.
+func (r *Runner) Manifest() Manifest {
+	return r.manifest
+}
+
+// setEnabled enables or disables the agent. It does not start or stop it.
+func (r *Runner) setEnabled(enabled bool) {
+	r.mu.Lock()
+	r.disabled = !enabled
+	r.mu.Unlock()
+}
+
+// active reports whether the agent is running or about to be.
+func (r *Runner) active() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.state == StateRunning || r.state == StateStarting || r.state == StateRestarting
+}
+
+// Logs returns the agent's captured stdout/stderr.
+func (r *Runner) Logs() *LogBuffer {
+	return r.logs
+}
+
 // Name returns the agent manifest name.
 func (r *Runner) Name() string {
 	return r.manifest.Name
@@ -234,6 +536,19 @@ type AgentStatus struct {
 	Skills []string `json:"skills"`
 	Port   int      `json:"port,omitempty"`
 	Error  string   `json:"error,omitempty"`
+
+	Enabled       bool       `json:"enabled"`
+	InFlight      int        `json:"in_flight"`
+	RestartPolicy string     `json:"restart_policy"`
+	Restarts      int        `json:"restarts"`
+	LastRestart   *time.Time `json:"last_restart,omitempty"`
+
+	MaxConcurrency int      `json:"max_concurrency,omitempty"` // 0 means unlimited
+	QueueDepth     int      `json:"queue_depth"`               // tasks waiting for a slot
+	QueueWait      Duration `json:"queue_wait"`                // moving average of time spent queued
+
+	ExitCode   *int   `json:"exit_code,omitempty"`   // -1 when killed by a signal
+	ExitSignal string `json:"exit_signal,omitempty"` // signal that terminated the process
 }
 
 func (r *Runner) setFailed(err error) {
@@ -245,41 +560,51 @@ func (r *Runner) setFailed(err error) {
 }
 
 // monitor waits for the process to exit. It is the only goroutine that calls cmd.Wait().
-func (r *Runner) monitor(cmd *exec.Cmd) {
+func (r *Runner) monitor(cmd *exec.Cmd, done chan struct{}, output ...*lineWriter) {
 	err := cmd.Wait()
+	for _, w := range output {
+		w.Flush()
+	}
 
 	r.mu.Lock()
-	defer r.mu.Unlock()
-
 	// Signal that the process has exited
-	if r.done != nil {
-		close(r.done)
-	}
+	close(done)
 
-	// Only mark as failed if we're still in Running state
-	// (Stop() sets state to Stopped before cancelling)
-	if r.state == StateRunning {
-		r.state = StateFailed
-		if err != nil {
-			r.err = fmt.Errorf("process exited unexpectedly: %w", err)
-		} else {
-			r.err = fmt.Errorf("process exited unexpectedly with code 0")
-		}
-		slog.Warn("agent process exited", "agent", r.manifest.Name, "error", r.err)
+	if r.done == done && cmd.ProcessState != nil {
+		r.exited = true
+		r.exitCode = cmd.ProcessState.ExitCode()
+		r.exitSignal = exitSignal(cmd.ProcessState)
 	}
-}
 
-// logWriter sends agent stderr output to slog.
-type logWriter struct {
-	name string
-}
+	// Only mark as failed if this is still the current process and we're
+	// still in Running state (Stop() sets state to Stopped before cancelling)
+	if r.done != done || r.state != StateRunning {
+		r.mu.Unlock()
+		return
+	}
+	r.state = StateFailed
+	if err != nil {
+		r.err = fmt.Errorf("process exited unexpectedly: %w", err)
+	} else {
+		r.err = fmt.Errorf("process exited unexpectedly with code 0")
+	}
+	client := r.client
+	cancel := r.cancel
+	r.client = nil
+	r.cancel = nil
+	r.mu.Unlock()
 
-func (w *logWriter) Write(p []byte) (int, error) {
-	lines := strings.Split(strings.TrimRight(string(p), "\n"), "\n")
-	for _, line := range lines {
-		if line != "" {
-			slog.Debug("agent stderr", "agent", w.name, "line", line)
-		}
+	slog.Warn("agent process exited", "agent", r.manifest.Name, "error", r.err)
+	// Workers the agent forked outlive it; stop them as Stop would.
+	if pid := cmd.Process.Pid; groupAlive(pid) {
+		terminateGroup(pid)
+		r.drainGroup(pid, time.Now().Add(r.manifest.stopTimeout()))
+	}
+	if client != nil {
+		client.Close()
+	}
+	if cancel != nil {
+		cancel()
 	}
-	return len(p), nil
+	r.scheduleRestart(err == nil)
 }
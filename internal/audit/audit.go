@@ -0,0 +1,230 @@
+// Package audit keeps a tamper-evident log of who changed what: config
+// edits, agent actions and task submissions. Each record carries the SHA-256
+// hash of the one before it, so editing or dropping a record breaks the
+// chain from that point on.
+package audit
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is one entry of the audit log.
+type Record struct {
+	Seq     int64     `json:"seq"`
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`  // token name: "admin", "web-ui" or an API token
+	Remote  string    `json:"remote"` // client address
+	Action  string    `json:"action"` // e.g. "config.patch", "agent.stop", "task.submit"
+	Target  string    `json:"target,omitempty"`
+	Summary string    `json:"summary,omitempty"`
+	Diff    []Change  `json:"diff,omitempty"`
+	Prev    string    `json:"prev_hash"` // hash of the previous record; empty for the first
+	Hash    string    `json:"hash"`
+}
+
+// hash returns the hex SHA-256 of the record's JSON without its own hash.
+func (r Record) hash() string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Filter selects audit records. Zero fields match everything.
+type Filter struct {
+	Actor  string
+	Action string // an action, or a prefix like "agent" for every agent.* action
+	Target string
+	Since  time.Time
+	Until  time.Time
+	Limit  int // 0 = no limit
+}
+
+func (f Filter) match(r *Record) bool {
+	return (f.Actor == "" || r.Actor == f.Actor) &&
+		(f.Action == "" || r.Action == f.Action || strings.HasPrefix(r.Action, f.Action+".")) &&
+		(f.Target == "" || r.Target == f.Target) &&
+		(f.Since.IsZero() || !r.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || !r.Time.After(f.Until))
+}
+
+// Log is the audit log. With a directory it is audit.jsonl there, one JSON
+// record per line, appended and fsynced as actions happen.
+type Log struct {
+	mu      sync.Mutex
+	f       *os.File // nil keeps the log in memory only
+	path    string
+	records []Record // in-memory log, when f is nil
+	seq     int64
+	last    string // hash of the last record
+}
+
+// Open opens (or creates) the audit log in dir. An empty dir keeps the log
+// in memory.
+func Open(dir string) (*Log, error) {
+	l := &Log{}
+	if dir == "" {
+		return l, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create audit dir: %w", err)
+	}
+	l.path = filepath.Join(dir, "audit.jsonl")
+	// New records chain onto the last readable one; Verify reports any
+	// damage before it.
+	n := 0
+	err := l.scan(func(line int, rec *Record, err error) {
+		if err != nil {
+			slog.Warn("audit log: unreadable record", "line", line, "error", err)
+			return
+		}
+		l.seq, l.last = rec.Seq, rec.Hash
+		n++
+	})
+	if err != nil {
+		return nil, err
+	}
+	l.f, err = os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	slog.Info("audit log opened", "records", n)
+	return l, nil
+}
+
+// Append completes rec with its sequence number, time and hashes and writes
+// it to the log.
+func (l *Log) Append(rec Record) (Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec.Seq = l.seq + 1
+	rec.Time = time.Now().UTC()
+	rec.Prev = l.last
+	rec.Hash = rec.hash()
+
+	if l.f == nil {
+		l.records = append(l.records, rec)
+	} else {
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return Record{}, fmt.Errorf("marshal audit record: %w", err)
+		}
+		if _, err := l.f.Write(append(data, '\n')); err != nil {
+			return Record{}, fmt.Errorf("write audit log: %w", err)
+		}
+		if err := l.f.Sync(); err != nil {
+			return Record{}, fmt.Errorf("sync audit log: %w", err)
+		}
+	}
+	l.seq, l.last = rec.Seq, rec.Hash
+	return rec, nil
+}
+
+// Query returns the records matching f, most recent first. Unreadable lines
+// are skipped.
+func (l *Log) Query(f Filter) ([]Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var matched []Record
+	err := l.scan(func(_ int, rec *Record, err error) {
+		if err == nil && f.match(rec) {
+			matched = append(matched, *rec)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Record, 0, len(matched))
+	for i := len(matched) - 1; i >= 0; i-- {
+		out = append(out, matched[i])
+		if f.Limit > 0 && len(out) >= f.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+// Verify walks the chain and returns the number of records checked. It fails
+// at the first line that is not a record, or whose hash does not match its
+// contents, or whose prev_hash does not match the record before it.
+func (l *Log) Verify() (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prev, n := "", 0
+	var broken error
+	err := l.scan(func(line int, rec *Record, err error) {
+		switch {
+		case broken != nil:
+		case err != nil:
+			broken = fmt.Errorf("line %d: not an audit record: %w", line, err)
+		case rec.Prev != prev:
+			broken = fmt.Errorf("line %d (record %d): prev_hash does not match the record before it", line, rec.Seq)
+		case rec.Hash != rec.hash():
+			broken = fmt.Errorf("line %d (record %d): hash does not match its contents", line, rec.Seq)
+		default:
+			prev = rec.Hash
+			n++
+		}
+	})
+	if err != nil {
+		return n, err
+	}
+	return n, broken
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// scan calls fn for every line of the log in order, with the record or the
+// reason the line is not one.
+func (l *Log) scan(fn func(line int, rec *Record, err error)) error {
+	if l.path == "" {
+		for i := range l.records {
+			fn(i+1, &l.records[i], nil)
+		}
+		return nil
+	}
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open audit log: %w", err)
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		var rec Record
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			fn(line, nil, err)
+			continue
+		}
+		fn(line, &rec, nil)
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("read audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerify(t *testing.T) {
+	// Each case edits the lines of a log of four records.
+	tests := []struct {
+		name    string
+		edit    func(lines []string) []string
+		wantN   int
+		wantErr string // substring; empty = intact
+	}{
+		{"intact", func(l []string) []string { return l }, 4, ""},
+		{"tampered summary", func(l []string) []string {
+			l[2] = strings.Replace(l[2], `"summary":"change 3"`, `"summary":"change 9"`, 1)
+			return l
+		}, 2, "line 3 (record 3): hash does not match"},
+		{"tampered actor with its hash recomputed", func(l []string) []string {
+			l[1] = rehash(t, strings.Replace(l[1], `"actor":"ci"`, `"actor":"admin"`, 1))
+			return l
+		}, 2, "line 3 (record 3): prev_hash does not match"},
+		{"dropped middle line", func(l []string) []string { return append(l[:1], l[2:]...) }, 1, "line 2 (record 3): prev_hash does not match"},
+		{"dropped first line", func(l []string) []string { return l[1:] }, 0, "line 1 (record 2): prev_hash does not match"},
+		{"swapped lines", func(l []string) []string {
+			l[1], l[2] = l[2], l[1]
+			return l
+		}, 1, "line 2 (record 3): prev_hash does not match"},
+		{"garbled line", func(l []string) []string {
+			l[3] = l[3][:10]
+			return l
+		}, 3, "line 4: not an audit record"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			l, err := Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 1; i <= 4; i++ {
+				if _, err := l.Append(Record{Actor: "ci", Action: "config.patch", Summary: fmt.Sprintf("change %d", i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			l.Close()
+
+			path := filepath.Join(dir, "audit.jsonl")
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := tt.edit(strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"))
+			if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			l, err = Open(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			n, err := l.Verify()
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Verify() error = %v, want %q", err, tt.wantErr)
+			}
+			if n != tt.wantN {
+				t.Errorf("Verify() checked %d records, want %d", n, tt.wantN)
+			}
+		})
+	}
+}
+
+func TestVerifyInMemory(t *testing.T) {
+	l, err := Open("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		l.Append(Record{Actor: "admin", Action: "agent.stop", Target: "echo"})
+	}
+	l.records[1].Target = "other"
+	if n, err := l.Verify(); n != 1 || err == nil {
+		t.Errorf("Verify() = %d, %v; want 1 record and an error", n, err)
+	}
+}
+
+// rehash recomputes the hash of a record line after it was edited.
+func rehash(t *testing.T, line string) string {
+	t.Helper()
+	var rec Record
+	if err := json.Unmarshal([]byte(line), &rec); err != nil {
+		t.Fatal(err)
+	}
+	rec.Hash = rec.hash()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Change is one value that differs between two versions of a document.
+// Old or New is absent when the value was added or removed.
+type Change struct {
+	Path string          `json:"path"` // e.g. "agents[0].enabled"
+	Old  json.RawMessage `json:"old,omitempty"`
+	New  json.RawMessage `json:"new,omitempty"`
+}
+
+// Diff compares the JSON forms of old and new and returns the values that
+// differ, by path. Objects are compared key by key and arrays of the same
+// length element by element; anything else that differs is one change.
+func Diff(old, new any) ([]Change, error) {
+	a, err := generic(old)
+	if err != nil {
+		return nil, err
+	}
+	b, err := generic(new)
+	if err != nil {
+		return nil, err
+	}
+	var out []Change
+	diff("", a, b, &out)
+	return out, nil
+}
+
+// generic round-trips v through JSON into maps, slices and scalars.
+func generic(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("marshal for diff: %w", err)
+	}
+	var out any
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("unmarshal for diff: %w", err)
+	}
+	return out, nil
+}
+
+func diff(path string, a, b any, out *[]Change) {
+	switch a := a.(type) {
+	case map[string]any:
+		if b, ok := b.(map[string]any); ok {
+			keys := make([]string, 0, len(a)+len(b))
+			for k := range a {
+				keys = append(keys, k)
+			}
+			for k := range b {
+				if _, ok := a[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				p := k
+				if path != "" {
+					p = path + "." + k
+				}
+				diff(p, a[k], b[k], out)
+			}
+			return
+		}
+	case []any:
+		if b, ok := b.([]any); ok && len(a) == len(b) {
+			for i := range a {
+				diff(fmt.Sprintf("%s[%d]", path, i), a[i], b[i], out)
+			}
+			return
+		}
+	}
+	if reflect.DeepEqual(a, b) {
+		return
+	}
+	c := Change{Path: path}
+	if a != nil {
+		c.Old, _ = json.Marshal(a)
+	}
+	if b != nil {
+		c.New, _ = json.Marshal(b)
+	}
+	*out = append(*out, c)
+}
@@ -0,0 +1,349 @@
+// Package batch runs many tasks from a JSONL file with bounded concurrency
+// and keeps their results in input order.
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"idra/internal/agent"
+	"idra/internal/agent/pb"
+)
+
+// Line is one line of a batch input file.
+type Line struct {
+	Skill    string            `json:"skill"`
+	Input    string            `json:"input"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	num int // line number in the input file
+}
+
+// Parse reads a JSONL batch file. Blank lines are ignored. A line that is
+// not valid JSON, has no skill (and defaultSkill is empty) or names an
+// unknown priority is returned with its error in errs at the same index, so
+// it shows up as a failed result instead of rejecting the whole batch.
+func Parse(r io.Reader, defaultSkill string) (lines []Line, errs []error, err error) {
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		raw, readErr := br.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return nil, nil, readErr
+		}
+		if raw = bytes.TrimSpace(raw); len(raw) > 0 {
+			var l Line
+			var lineErr error
+			if err := json.Unmarshal(raw, &l); err != nil {
+				lineErr = err
+			} else {
+				if l.Skill == "" {
+					l.Skill = defaultSkill
+				}
+				if l.Skill == "" {
+					lineErr = errors.New("skill is required")
+				} else if _, err := agent.ParsePriority(l.Metadata["priority"]); err != nil {
+					lineErr = err
+				}
+			}
+			l.num = n
+			lines = append(lines, l)
+			errs = append(errs, lineErr)
+		}
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if len(lines) == 0 {
+		return nil, nil, errors.New("batch is empty")
+	}
+	return lines, errs, nil
+}
+
+// State is the lifecycle state of a batch or one of its lines.
+type State string
+
+const (
+	StatePending   State = "pending"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled" // a batch stopped by Cancel, or a line it never ran
+	StateCompleted State = "completed" // a batch whose lines have all run, whatever their outcome
+)
+
+// Batch is the progress record of a batch.
+type Batch struct {
+	ID          string `json:"id"`
+	State       State  `json:"state"`
+	Concurrency int    `json:"concurrency"`
+	Total       int    `json:"total"`
+	Done        int    `json:"done"`
+	Succeeded   int    `json:"succeeded"`
+	Failed      int    `json:"failed"`
+
+	Skills []string `json:"skills"` // skills of the lines it runs, sorted
+
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Result is the outcome of one line, written to the results file in input
+// order.
+type Result struct {
+	Line   int    `json:"line"` // line number in the input file
+	State  State  `json:"state"`
+	TaskID string `json:"task_id,omitempty"`
+	Agent  string `json:"agent,omitempty"`
+	Result string `json:"result,omitempty"` // payload of the task's last "result" event
+	Error  string `json:"error,omitempty"`
+}
+
+// Executor runs one line's task on a provider of its skill and returns the
+// agent that handled it and the events it produced. A task that ends with an
+// "error", "timeout" or "cancelled" event fails the line.
+type Executor func(ctx context.Context, req *pb.TaskRequest, agentName string, timeout time.Duration) (string, []*pb.TaskEvent, error)
+
+type job struct {
+	Batch
+	results []Result
+	cancel  context.CancelFunc
+}
+
+// Manager runs batches and keeps the most recent ones in memory.
+type Manager struct {
+	exec Executor
+
+	mu    sync.RWMutex
+	jobs  map[string]*job
+	order []string // batch IDs in creation order
+	limit int
+}
+
+// NewManager creates a manager that executes lines with exec and retains up
+// to limit finished batches.
+func NewManager(exec Executor, limit int) *Manager {
+	return &Manager{exec: exec, jobs: make(map[string]*job), limit: limit}
+}
+
+// Start runs lines in the background, at most concurrency at a time, each
+// with the given task timeout. errs holds parse errors from Parse; lines
+// with an error fail without running.
+func (m *Manager) Start(lines []Line, errs []error, concurrency int, timeout time.Duration) Batch {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		Batch: Batch{
+			ID:          newID("batch"),
+			State:       StateRunning,
+			Concurrency: concurrency,
+			Total:       len(lines),
+			CreatedAt:   time.Now().UTC(),
+		},
+		results: make([]Result, len(lines)),
+		cancel:  cancel,
+	}
+	for i := range j.results {
+		j.results[i] = Result{Line: lines[i].num, State: StatePending}
+		if lines[i].num == 0 {
+			j.results[i].Line = i + 1
+		}
+		if i < len(errs) && errs[i] != nil {
+			j.results[i].State = StateFailed
+			j.results[i].Error = errs[i].Error()
+			j.Done++
+			j.Failed++
+		}
+	}
+
+	var pending []int
+	for i, r := range j.results {
+		if r.State == StatePending {
+			pending = append(pending, i)
+			j.Skills = append(j.Skills, lines[i].Skill)
+		}
+	}
+	slices.Sort(j.Skills)
+	j.Skills = slices.Compact(j.Skills)
+
+	m.mu.Lock()
+	m.jobs[j.ID] = j
+	m.order = append(m.order, j.ID)
+	m.evict()
+	snap := j.Batch
+	m.mu.Unlock()
+
+	go m.run(ctx, j, lines, pending, timeout)
+	return snap
+}
+
+// run executes the pending lines of j with a pool of workers.
+func (m *Manager) run(ctx context.Context, j *job, lines []Line, pending []int, timeout time.Duration) {
+	defer j.cancel()
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range j.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				m.runLine(ctx, j, i, lines[i], timeout)
+			}
+		}()
+	}
+feed:
+	for _, i := range pending {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(next)
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	j.FinishedAt = &now
+	j.State = StateCompleted
+	if ctx.Err() != nil {
+		j.State = StateCancelled
+		for i := range j.results {
+			if j.results[i].State == StatePending {
+				j.results[i].State = StateCancelled
+			}
+		}
+	}
+}
+
+func (m *Manager) runLine(ctx context.Context, j *job, i int, l Line, timeout time.Duration) {
+	req := &pb.TaskRequest{
+		TaskId:   newID("task"),
+		Skill:    l.Skill,
+		Input:    l.Input,
+		Metadata: l.Metadata,
+	}
+	m.mu.Lock()
+	j.results[i].State = StateRunning
+	j.results[i].TaskID = req.TaskId
+	m.mu.Unlock()
+
+	agentName, events, err := m.exec(ctx, req, "", timeout)
+	if err == nil {
+		if n := len(events); n > 0 {
+			switch last := events[n-1]; last.Type {
+			case "error", "timeout", "cancelled":
+				err = fmt.Errorf("%s: %s", last.Type, last.Payload)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := &j.results[i]
+	r.Agent = agentName
+	j.Done++
+	if err != nil && ctx.Err() != nil {
+		r.State = StateCancelled
+		r.Error = err.Error()
+		return
+	}
+	if err != nil {
+		r.State = StateFailed
+		r.Error = err.Error()
+		j.Failed++
+		return
+	}
+	r.State = StateSucceeded
+	for _, ev := range events {
+		if ev.Type == "result" {
+			r.Result = ev.Payload
+		}
+	}
+	j.Succeeded++
+}
+
+// Get returns the progress of the batch with the given ID.
+func (m *Manager) Get(id string) (Batch, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Batch{}, false
+	}
+	return j.Batch, true
+}
+
+// List returns all retained batches, newest first.
+func (m *Manager) List() []Batch {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]Batch, 0, len(m.order))
+	for i := len(m.order) - 1; i >= 0; i-- {
+		out = append(out, m.jobs[m.order[i]].Batch)
+	}
+	return out
+}
+
+// Results returns a copy of the batch's per-line results in input order.
+// Lines that haven't finished are reported as pending or running.
+func (m *Manager) Results(id string) ([]Result, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, false
+	}
+	return append([]Result(nil), j.results...), true
+}
+
+// Cancel stops a running batch: lines in flight are cancelled and the rest
+// never run. It reports whether the batch exists.
+func (m *Manager) Cancel(id string) bool {
+	m.mu.RLock()
+	j, ok := m.jobs[id]
+	m.mu.RUnlock()
+	if ok {
+		j.cancel()
+	}
+	return ok
+}
+
+// WriteResults writes results as JSONL.
+func WriteResults(w io.Writer, results []Result) error {
+	enc := json.NewEncoder(w)
+	for _, r := range results {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evict drops the oldest finished batches while over the limit. Caller must
+// hold mu.
+func (m *Manager) evict() {
+	for i := 0; len(m.jobs) > m.limit && i < len(m.order); {
+		id := m.order[i]
+		if m.jobs[id].FinishedAt == nil {
+			i++
+			continue
+		}
+		delete(m.jobs, id)
+		m.order = append(m.order[:i], m.order[i+1:]...)
+	}
+}
+
+func newID(prefix string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%x", prefix, b)
+}
@@ -2,27 +2,143 @@ package config
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
 	"idra/internal/platform"
 )
 
-// AgentConfig overrides for an individual agent (optional, for future use).
+// AgentConfig overrides for an individual agent.
 type AgentConfig struct {
 	Name    string `json:"name"`
 	Enabled *bool  `json:"enabled,omitempty"` // nil = true (default enabled)
+
+	// Overrides replaces manifest.json fields for this agent, e.g.
+	// {"args": ["agent.py", "--fast"], "env": {"LOG_LEVEL": "debug"}}.
+	// Nested objects such as "restart" are merged field by field.
+	Overrides json.RawMessage `json:"overrides,omitempty"`
+}
+
+// IsEnabled reports whether the agent should be started.
+func (a AgentConfig) IsEnabled() bool {
+	return a.Enabled == nil || *a.Enabled
+}
+
+// SkillConfig selects how tasks for a skill are spread across the agents
+// that provide it, how failed tasks are retried and whether results are
+// cached.
+type SkillConfig struct {
+	Name     string       `json:"name"`
+	Strategy string       `json:"strategy,omitempty"` // priority (default), round-robin, least-in-flight, weighted
+	Retry    *RetryConfig `json:"retry,omitempty"`    // nil = no retries
+	Cache    *CacheConfig `json:"cache,omitempty"`    // nil = no caching
+}
+
+// RetryConfig retries tasks that fail before producing any event. Zero
+// values take the defaults: 3 attempts, "500ms" backoff doubling up to
+// "10s", retrying the "unavailable" and "not-running" error classes.
+type RetryConfig struct {
+	MaxAttempts int      `json:"max_attempts,omitempty"` // including the first attempt
+	Backoff     string   `json:"backoff,omitempty"`
+	MaxBackoff  string   `json:"max_backoff,omitempty"`
+	On          []string `json:"on,omitempty"` // unavailable, not-running, resource-exhausted, queue-full, timeout
+}
+
+// CacheConfig caches successful task results. Zero values take the
+// defaults: a "5m" TTL and 1000 entries.
+type CacheConfig struct {
+	TTL        string `json:"ttl,omitempty"`
+	MaxEntries int    `json:"max_entries,omitempty"`
+	Persist    bool   `json:"persist,omitempty"` // keep entries across restarts
+}
+
+// ScheduleConfig runs a task on a cron schedule.
+type ScheduleConfig struct {
+	Name     string            `json:"name"`
+	Cron     string            `json:"cron"`                // "minute hour day-of-month month day-of-week" or a macro like "@daily"
+	TimeZone string            `json:"time_zone,omitempty"` // IANA name, e.g. "Europe/Rome"; empty = local time
+	Skill    string            `json:"skill"`
+	Agent    string            `json:"agent,omitempty"` // empty routes by skill
+	Input    string            `json:"input,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Timeout  string            `json:"timeout,omitempty"` // e.g. "5m"
+	Overlap  string            `json:"overlap,omitempty"` // skip (default), queue, allow
+	Enabled  *bool             `json:"enabled,omitempty"` // nil = true
+}
+
+// IsEnabled reports whether the schedule should fire.
+func (s ScheduleConfig) IsEnabled() bool {
+	return s.Enabled == nil || *s.Enabled
+}
+
+// WebhookConfig allows task callbacks to URLs starting with URL and holds
+// the secret their payloads are signed with.
+type WebhookConfig struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`    // prefix of the allowed callback URLs
+	Secret string `json:"secret"` // HMAC-SHA256 key
+}
+
+// API token scopes.
+const (
+	ScopeRead         = "read"          // GET endpoints
+	ScopeTasksExecute = "tasks:execute" // submit, cancel and re-drive tasks
+	ScopeAgentsManage = "agents:manage" // start, stop and restart agents
+	ScopeConfigWrite  = "config:write"  // change config.json, schedules and tokens
+)
+
+// Scopes lists every API token scope.
+var Scopes = []string{ScopeRead, ScopeTasksExecute, ScopeAgentsManage, ScopeConfigWrite}
+
+// Names that callers without an API token are known by: the bearer token and
+// login-link sessions of the web UI. API tokens may not use them.
+const (
+	AdminName = "admin"
+	WebUIName = "web-ui"
+)
+
+// TokenConfig is a named API token. Only the SHA-256 hash of the token is
+// stored; the token itself is shown once, when it is created.
+type TokenConfig struct {
+	Name      string     `json:"name"`
+	Hash      string     `json:"hash"` // hex SHA-256 of the token
+	Scopes    []string   `json:"scopes"`
+	Skills    []string   `json:"skills,omitempty"`     // skills the token may run tasks for; empty = all
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil = never
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Expired reports whether the token is past its expiry.
+func (t TokenConfig) Expired() bool {
+	return t.ExpiresAt != nil && time.Now().After(*t.ExpiresAt)
+}
+
+// RetiredToken is a rotated-out bearer token that stays valid until
+// ExpiresAt. Only its hash is kept.
+type RetiredToken struct {
+	Hash      string    `json:"hash"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
 type Config struct {
-	Port        int           `json:"port"`
-	BearerToken string        `json:"bearer_token"`
-	AutoOpen    bool          `json:"auto_open_browser"`
-	Agents      []AgentConfig `json:"agents,omitempty"`
+	Port         int              `json:"port"`
+	BearerToken  string           `json:"bearer_token"` // admin token with every scope
+	RetiredToken *RetiredToken    `json:"retired_bearer_token,omitempty"`
+	AutoOpen     bool             `json:"auto_open_browser"`
+	Agents       []AgentConfig    `json:"agents,omitempty"`
+	Skills       []SkillConfig    `json:"skills,omitempty"`
+	Schedules    []ScheduleConfig `json:"schedules,omitempty"`
+	Webhooks     []WebhookConfig  `json:"webhooks,omitempty"`
+	Tokens       []TokenConfig    `json:"tokens,omitempty"`
 }
 
 func Default() Config {
@@ -84,10 +200,13 @@ func Update(fn func(*Config)) (Config, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	fn(&current)
-	if err := validate(current); err != nil {
+	// Work on a copy so a failed validation leaves current untouched.
+	c := current
+	fn(&c)
+	if err := validate(c); err != nil {
 		return current, err
 	}
+	current = c
 	return current, save()
 }
 
@@ -95,8 +214,11 @@ func Replace(c Config) (Config, error) {
 	mu.Lock()
 	defer mu.Unlock()
 
-	// Preserve bearer token — it cannot be changed via API
+	// Preserve bearer token and API tokens — they are managed through the
+	// token API, not by replacing the config
 	c.BearerToken = current.BearerToken
+	c.RetiredToken = current.RetiredToken
+	c.Tokens = current.Tokens
 	if err := validate(c); err != nil {
 		return current, err
 	}
@@ -104,6 +226,26 @@ func Replace(c Config) (Config, error) {
 	return current, save()
 }
 
+// RotateBearerToken replaces the bearer token with a new one and returns it.
+// With a positive grace the old token keeps working until grace has passed;
+// a token retired by an earlier rotation stops working either way.
+func RotateBearerToken(grace time.Duration) (string, Config, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	c := current
+	c.RetiredToken = nil
+	if grace > 0 {
+		c.RetiredToken = &RetiredToken{
+			Hash:      HashToken(c.BearerToken),
+			ExpiresAt: time.Now().UTC().Add(grace),
+		}
+	}
+	c.BearerToken = generateToken()
+	current = c
+	return c.BearerToken, current, save()
+}
+
 // save writes current config to disk. Caller must hold mu.
 func save() error {
 	dir := filepath.Dir(filePath)
@@ -130,9 +272,124 @@ func validate(c Config) error {
 	if c.Port < 1 || c.Port > 65535 {
 		return fmt.Errorf("port must be between 1 and 65535, got %d", c.Port)
 	}
+	seen := make(map[string]bool, len(c.Agents))
+	for _, a := range c.Agents {
+		if a.Name == "" {
+			return fmt.Errorf("agents: name is required")
+		}
+		if seen[a.Name] {
+			return fmt.Errorf("agents: duplicate entry for %q", a.Name)
+		}
+		seen[a.Name] = true
+	}
+	seen = make(map[string]bool, len(c.Skills))
+	for _, sk := range c.Skills {
+		if sk.Name == "" {
+			return fmt.Errorf("skills: name is required")
+		}
+		if seen[sk.Name] {
+			return fmt.Errorf("skills: duplicate entry for %q", sk.Name)
+		}
+		seen[sk.Name] = true
+	}
+	seen = make(map[string]bool, len(c.Schedules))
+	for _, sc := range c.Schedules {
+		if sc.Name == "" {
+			return fmt.Errorf("schedules: name is required")
+		}
+		if seen[sc.Name] {
+			return fmt.Errorf("schedules: duplicate entry for %q", sc.Name)
+		}
+		seen[sc.Name] = true
+	}
+	seen = make(map[string]bool, len(c.Webhooks))
+	for _, wh := range c.Webhooks {
+		if wh.Name == "" {
+			return fmt.Errorf("webhooks: name is required")
+		}
+		if seen[wh.Name] {
+			return fmt.Errorf("webhooks: duplicate entry for %q", wh.Name)
+		}
+		seen[wh.Name] = true
+		if !strings.HasPrefix(wh.URL, "http://") && !strings.HasPrefix(wh.URL, "https://") {
+			return fmt.Errorf("webhooks: %q: url must start with http:// or https://", wh.Name)
+		}
+		if wh.Secret == "" {
+			return fmt.Errorf("webhooks: %q: secret is required", wh.Name)
+		}
+	}
+	seen = make(map[string]bool, len(c.Tokens))
+	for _, t := range c.Tokens {
+		if t.Name == "" {
+			return fmt.Errorf("tokens: name is required")
+		}
+		if t.Name == AdminName || t.Name == WebUIName {
+			return fmt.Errorf("tokens: %q is a reserved name", t.Name)
+		}
+		if seen[t.Name] {
+			return fmt.Errorf("tokens: duplicate entry for %q", t.Name)
+		}
+		seen[t.Name] = true
+		if len(t.Hash) != sha256.Size*2 {
+			return fmt.Errorf("tokens: %q: hash must be a hex SHA-256", t.Name)
+		}
+		if len(t.Scopes) == 0 {
+			return fmt.Errorf("tokens: %q: at least one scope is required", t.Name)
+		}
+		for _, sc := range t.Scopes {
+			if !slices.Contains(Scopes, sc) {
+				return fmt.Errorf("tokens: %q: unknown scope %q", t.Name, sc)
+			}
+		}
+	}
 	return nil
 }
 
+// WebhookFor returns the webhook that callbackURL belongs to: the same
+// scheme and host, and a path under the webhook's, matched on a "/"
+// boundary. If several match, the one with the longest path wins.
+func (c Config) WebhookFor(callbackURL string) (WebhookConfig, bool) {
+	cb, err := url.Parse(callbackURL)
+	if err != nil || cb.Host == "" {
+		return WebhookConfig{}, false
+	}
+	var best WebhookConfig
+	bestLen, found := -1, false
+	for _, wh := range c.Webhooks {
+		u, err := url.Parse(wh.URL)
+		if err != nil || !strings.EqualFold(u.Scheme, cb.Scheme) || !strings.EqualFold(u.Host, cb.Host) {
+			continue
+		}
+		if underPath(cb.Path, u.Path) && len(u.Path) > bestLen {
+			best, bestLen, found = wh, len(u.Path), true
+		}
+	}
+	return best, found
+}
+
+// underPath reports whether path is base or below it.
+func underPath(path, base string) bool {
+	if base == "" || base == "/" {
+		return true
+	}
+	if strings.HasSuffix(base, "/") {
+		return strings.HasPrefix(path, base)
+	}
+	return path == base || strings.HasPrefix(path, base+"/")
+}
+
+// NewToken returns a random API token and the hash to store for it.
+func NewToken() (token, hash string) {
+	token = "idra_" + generateToken()
+	return token, HashToken(token)
+}
+
+// HashToken returns the hex SHA-256 of an API token.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func generateToken() string {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
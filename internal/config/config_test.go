@@ -0,0 +1,59 @@
+package config
+
+import "testing"
+
+func TestWebhookFor(t *testing.T) {
+	c := Config{Webhooks: []WebhookConfig{
+		{Name: "root", URL: "https://ci.example.com"},
+		{Name: "hooks", URL: "https://ci.example.com/hooks/"},
+		{Name: "exact", URL: "https://ci.example.com/deploy"},
+	}}
+	tests := []struct {
+		url  string
+		want string // webhook name; empty = no match
+	}{
+		{"https://ci.example.com/x", "root"},
+		{"https://CI.example.com/x", "root"},
+		{"https://ci.example.com/hooks/idra", "hooks"},
+		{"https://ci.example.com/deploy", "exact"},
+		{"https://ci.example.com/deploy/42", "exact"},
+		{"https://ci.example.com/deployer", "root"},
+		{"https://ci.example.com.attacker.net/x", ""},
+		{"https://ci.example.com@attacker.net/x", ""},
+		{"https://ci.example.com:8443/x", ""},
+		{"http://ci.example.com/x", ""},
+		{"/relative/path", ""},
+		{"::not a url", ""},
+	}
+	for _, tt := range tests {
+		wh, ok := c.WebhookFor(tt.url)
+		got := ""
+		if ok {
+			got = wh.Name
+		}
+		if got != tt.want {
+			t.Errorf("WebhookFor(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestValidateTokenNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"ci", false},
+		{AdminName, true},
+		{WebUIName, true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+			c.Tokens = []TokenConfig{{Name: tt.name, Hash: HashToken("idra_x"), Scopes: []string{ScopeRead}}}
+			if err := validate(c); (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
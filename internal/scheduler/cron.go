@@ -0,0 +1,221 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed five-field cron expression: minute, hour, day of month,
+// month and day of week. Fields accept "*", numbers, ranges ("1-5"), steps
+// ("*/15", "0-30/10"), lists ("1,15") and, for months and days of the week,
+// three-letter names. As in Vixie cron, when both the day of month and the
+// day of week are restricted a day matching either one fires. The macros
+// @yearly, @annually, @monthly, @weekly, @daily, @midnight and @hourly are
+// also accepted.
+type Cron struct {
+	minute, hour, dom, month, dow uint64 // bit n set = value n matches
+	domStar, dowStar              bool
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dowNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// ParseCron parses a cron expression.
+func ParseCron(expr string) (*Cron, error) {
+	expr = strings.TrimSpace(expr)
+	if m, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: want 5 fields (minute hour day-of-month month day-of-week), got %d", expr, len(fields))
+	}
+
+	// As in Vixie cron, a field starting with "*" counts as unrestricted.
+	c := &Cron{domStar: strings.HasPrefix(fields[2], "*"), dowStar: strings.HasPrefix(fields[4], "*")}
+	var err error
+	if c.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("cron %q: minute: %w", expr, err)
+	}
+	if c.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("cron %q: hour: %w", expr, err)
+	}
+	if c.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("cron %q: day of month: %w", expr, err)
+	}
+	if c.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("cron %q: month: %w", expr, err)
+	}
+	// Day of week 7 is Sunday, like 0.
+	if c.dow, err = parseCronField(fields[4], 0, 7, dowNames); err != nil {
+		return nil, fmt.Errorf("cron %q: day of week: %w", expr, err)
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and steps
+// between lo and hi. names, if set, are accepted for lo, lo+1, ...
+func parseCronField(field string, lo, hi int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+			step = n
+		}
+
+		var from, to int
+		switch {
+		case rng == "*":
+			from, to = lo, hi
+		case strings.Contains(rng, "-"):
+			a, b, _ := strings.Cut(rng, "-")
+			var err error
+			if from, err = cronValue(a, lo, hi, names); err != nil {
+				return 0, err
+			}
+			if to, err = cronValue(b, lo, hi, names); err != nil {
+				return 0, err
+			}
+			if from > to {
+				return 0, fmt.Errorf("invalid range %q", rng)
+			}
+		default:
+			v, err := cronValue(rng, lo, hi, names)
+			if err != nil {
+				return 0, err
+			}
+			// "5/10" means every 10 starting at 5.
+			from, to = v, v
+			if hasStep {
+				to = hi
+			}
+		}
+		for v := from; v <= to; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func cronValue(s string, lo, hi int, names []string) (int, error) {
+	for i, n := range names {
+		if strings.EqualFold(s, n) {
+			return lo + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < lo || v > hi {
+		return 0, fmt.Errorf("value %q out of range %d-%d", s, lo, hi)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t that matches, in t's location, or the
+// zero time if there is none within five years (e.g. "0 0 30 2 *"). A local
+// time skipped by a daylight-saving change fires once, at the first instant
+// after the gap: "30 2 * * *" fires at 3:00 on the night clocks jump from
+// 2:00 to 3:00.
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Year() + 5
+	for t.Year() <= limit {
+		var n time.Time
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			n = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.dayMatches(t):
+			n = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			n = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			n = t.Add(time.Minute)
+		default:
+			return t
+		}
+		// A midnight that falls in a daylight-saving gap can normalize to
+		// an earlier time; always move forward.
+		if !n.After(t) {
+			n = t.Add(time.Minute)
+		}
+		if x, ok := c.gapMatch(t, n); ok {
+			return x
+		}
+		t = n
+	}
+	return time.Time{}
+}
+
+// gapMatch reports whether a daylight-saving change between t and n skips
+// a local time that matches, and if so returns the instant the gap ends.
+func (c *Cron) gapMatch(t, n time.Time) (time.Time, bool) {
+	_, before := t.Zone()
+	_, after := n.Zone()
+	if after <= before {
+		return time.Time{}, false
+	}
+	// Find the first minute on the new offset.
+	lo, hi := t, n
+	for hi.Sub(lo) > time.Minute {
+		mid := lo.Add(hi.Sub(lo) / 2).Truncate(time.Minute)
+		if !mid.After(lo) {
+			break
+		}
+		if _, off := mid.Zone(); off == before {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	// The skipped wall-clock minutes end where the new offset starts.
+	end := wallClock(hi)
+	for m := end.Add(-time.Duration(after-before) * time.Second); m.Before(end); m = m.Add(time.Minute) {
+		if c.matches(m) {
+			return hi, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// matches reports whether t's fields match the expression.
+func (c *Cron) matches(t time.Time) bool {
+	return c.month&(1<<uint(t.Month())) != 0 && c.dayMatches(t) &&
+		c.hour&(1<<uint(t.Hour())) != 0 && c.minute&(1<<uint(t.Minute())) != 0
+}
+
+// wallClock returns t's local date and time as the same reading in UTC, so
+// it can be stepped through minutes that do not exist in t's location.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, time.UTC)
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
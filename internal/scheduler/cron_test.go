@@ -0,0 +1,87 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr bool
+	}{
+		{"* * * * *", false},
+		{"*/15 9-17 * * mon-fri", false},
+		{"0 0 1,15 jan,JUL *", false},
+		{"5/10 * * * 7", false},
+		{"@daily", false},
+		{"@HOURLY", false},
+		{"* * * *", true},
+		{"* * * * * *", true},
+		{"60 * * * *", true},
+		{"* 24 * * *", true},
+		{"* * 0 * *", true},
+		{"* * * 13 *", true},
+		{"* * * * 8", true},
+		{"*/0 * * * *", true},
+		{"5-1 * * * *", true},
+		{"* * * foo *", true},
+		{"@reboot", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := ParseCron(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCron(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	rome, err := time.LoadLocation("Europe/Rome")
+	if err != nil {
+		t.Skip("no tz database:", err)
+	}
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no tz database:", err)
+	}
+	date := func(loc *time.Location, y int, mo time.Month, d, h, mi int) time.Time {
+		return time.Date(y, mo, d, h, mi, 0, 0, loc)
+	}
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time // zero = never
+	}{
+		{"next minute", "* * * * *", date(time.UTC, 2026, 1, 1, 10, 0).Add(30 * time.Second), date(time.UTC, 2026, 1, 1, 10, 1)},
+		{"strictly after", "0 10 * * *", date(time.UTC, 2026, 1, 1, 10, 0), date(time.UTC, 2026, 1, 2, 10, 0)},
+		{"step", "*/15 * * * *", date(time.UTC, 2026, 1, 1, 10, 16), date(time.UTC, 2026, 1, 1, 10, 30)},
+		{"weekday", "0 9 * * mon-fri", date(time.UTC, 2026, 1, 2, 9, 0), date(time.UTC, 2026, 1, 5, 9, 0)},
+		{"sunday as 7", "0 0 * * 7", date(time.UTC, 2026, 1, 1, 0, 0), date(time.UTC, 2026, 1, 4, 0, 0)},
+		{"day of month or week", "0 0 13 * fri", date(time.UTC, 2026, 1, 1, 0, 0), date(time.UTC, 2026, 1, 2, 0, 0)},
+		{"month rollover", "0 0 1 * *", date(time.UTC, 2026, 12, 15, 0, 0), date(time.UTC, 2027, 1, 1, 0, 0)},
+		{"leap day", "0 0 29 2 *", date(time.UTC, 2026, 3, 1, 0, 0), date(time.UTC, 2028, 2, 29, 0, 0)},
+		{"never", "0 0 30 2 *", date(time.UTC, 2026, 1, 1, 0, 0), time.Time{}},
+		{"in location", "0 2 * * *", date(rome, 2026, 1, 1, 3, 0), date(rome, 2026, 1, 2, 2, 0)},
+		{"spring-forward gap fires after it", "30 2 * * *", date(ny, 2026, 3, 8, 0, 0), date(ny, 2026, 3, 8, 3, 0)},
+		{"gap fires once", "30 2 * * *", date(ny, 2026, 3, 8, 3, 0), date(ny, 2026, 3, 9, 2, 30)},
+		{"hourly across the gap", "0 * * * *", date(ny, 2026, 3, 8, 1, 30), date(ny, 2026, 3, 8, 3, 0)},
+		{"minute after the gap unaffected", "15 3 * * *", date(ny, 2026, 3, 8, 1, 0), date(ny, 2026, 3, 8, 3, 15)},
+		{"gap in another zone", "30 2 * * *", date(rome, 2026, 3, 29, 1, 0), date(rome, 2026, 3, 29, 3, 0)},
+		{"no gap on other days", "30 2 * * *", date(ny, 2026, 3, 7, 0, 0), date(ny, 2026, 3, 7, 2, 30)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := c.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
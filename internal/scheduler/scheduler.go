@@ -0,0 +1,399 @@
+// Package scheduler runs tasks on cron schedules defined in config.json.
+package scheduler
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"sync"
+	"time"
+
+	// Schedules name IANA time zones, and not every OS ships the database.
+	_ "time/tzdata"
+
+	"idra/internal/agent/pb"
+	"idra/internal/config"
+)
+
+// ErrUnknownSchedule is returned for a schedule name that is not configured.
+var ErrUnknownSchedule = errors.New("unknown schedule")
+
+// Overlap decides what happens when a schedule fires while its previous run
+// is still executing.
+type Overlap string
+
+const (
+	OverlapSkip  Overlap = "skip"  // record the firing as skipped (default)
+	OverlapQueue Overlap = "queue" // run it after the runs before it finish
+	OverlapAllow Overlap = "allow" // run it concurrently
+)
+
+// historyLimit is the number of runs kept per schedule.
+const historyLimit = 50
+
+// Executor runs a scheduled task on agentName, or on a provider of the skill
+// if agentName is empty, and returns the agent that handled it and the
+// events it produced.
+type Executor func(ctx context.Context, req *pb.TaskRequest, agentName string, timeout time.Duration) (string, []*pb.TaskEvent, error)
+
+// Schedule is a validated schedule entry.
+type Schedule struct {
+	config.ScheduleConfig
+	cron    *Cron
+	loc     *time.Location
+	timeout time.Duration
+	overlap Overlap
+}
+
+// Parse validates a schedule entry from config.json.
+func Parse(c config.ScheduleConfig) (*Schedule, error) {
+	s := &Schedule{ScheduleConfig: c, loc: time.Local, overlap: OverlapSkip}
+	if c.Skill == "" {
+		return nil, fmt.Errorf("schedule %q: skill is required", c.Name)
+	}
+	var err error
+	if s.cron, err = ParseCron(c.Cron); err != nil {
+		return nil, fmt.Errorf("schedule %q: %w", c.Name, err)
+	}
+	if c.TimeZone != "" {
+		if s.loc, err = time.LoadLocation(c.TimeZone); err != nil {
+			return nil, fmt.Errorf("schedule %q: unknown time zone %q", c.Name, c.TimeZone)
+		}
+	}
+	if c.Timeout != "" {
+		if s.timeout, err = time.ParseDuration(c.Timeout); err != nil || s.timeout < 0 {
+			return nil, fmt.Errorf("schedule %q: timeout must be a non-negative duration like \"5m\"", c.Name)
+		}
+	}
+	switch o := Overlap(c.Overlap); o {
+	case "":
+	case OverlapSkip, OverlapQueue, OverlapAllow:
+		s.overlap = o
+	default:
+		return nil, fmt.Errorf("schedule %q: unknown overlap policy %q", c.Name, c.Overlap)
+	}
+	return s, nil
+}
+
+// Next returns the first firing time after t, in the schedule's time zone,
+// or the zero time if it never fires.
+func (s *Schedule) Next(t time.Time) time.Time {
+	return s.cron.Next(t.In(s.loc))
+}
+
+// RunState is the state of one scheduled run.
+type RunState string
+
+const (
+	RunRunning   RunState = "running"
+	RunSucceeded RunState = "succeeded"
+	RunFailed    RunState = "failed"
+	RunTimedOut  RunState = "timed_out"
+	RunCancelled RunState = "cancelled"
+	RunSkipped   RunState = "skipped" // fired while the previous run was executing
+)
+
+// Run is the record of one firing of a schedule.
+type Run struct {
+	TaskID      string     `json:"task_id,omitempty"`
+	ScheduledAt time.Time  `json:"scheduled_at"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	State       RunState   `json:"state"`
+	Agent       string     `json:"agent,omitempty"`
+	Result      string     `json:"result,omitempty"` // payload of the task's last "result" event
+	Error       string     `json:"error,omitempty"`
+}
+
+// Status is a schedule with its runtime state.
+type Status struct {
+	config.ScheduleConfig
+	NextRun *time.Time `json:"next_run,omitempty"`
+	Running int        `json:"running"`
+	Queued  int        `json:"queued"`
+	LastRun *Run       `json:"last_run,omitempty"`
+}
+
+// Scheduler fires the configured schedules and keeps their recent runs.
+type Scheduler struct {
+	exec   Executor
+	ctx    context.Context // cancelled by Stop; scheduled tasks run under it
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	jobs  map[string]*job
+	order []string // schedule names in config order
+}
+
+// job is the runtime state of one schedule. Fields are guarded by
+// Scheduler.mu.
+type job struct {
+	sched   *Schedule
+	reset   chan struct{} // signals the loop to recompute the next firing
+	stop    chan struct{} // closed when the schedule is removed
+	next    time.Time
+	running int
+	queued  []time.Time
+	runs    []*Run // oldest first
+}
+
+// New creates a scheduler that executes tasks with exec. It fires nothing
+// until Apply is called.
+func New(exec Executor) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{exec: exec, ctx: ctx, cancel: cancel, jobs: make(map[string]*job)}
+}
+
+// Validate checks schedule entries without applying them.
+func Validate(cfgs []config.ScheduleConfig) error {
+	for _, c := range cfgs {
+		if _, err := Parse(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply replaces the set of schedules. Unchanged schedules keep their timers,
+// changed ones recompute their next firing and keep their run history, and
+// removed ones stop firing; runs already executing are left to finish. If
+// any entry is invalid nothing changes.
+func (s *Scheduler) Apply(cfgs []config.ScheduleConfig) error {
+	scheds := make([]*Schedule, 0, len(cfgs))
+	for _, c := range cfgs {
+		sc, err := Parse(c)
+		if err != nil {
+			return err
+		}
+		scheds = append(scheds, sc)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keep := make(map[string]bool, len(scheds))
+	s.order = s.order[:0]
+	for _, sc := range scheds {
+		keep[sc.Name] = true
+		s.order = append(s.order, sc.Name)
+		j, ok := s.jobs[sc.Name]
+		if !ok {
+			j = &job{sched: sc, reset: make(chan struct{}, 1), stop: make(chan struct{})}
+			s.jobs[sc.Name] = j
+			go s.loop(j)
+			continue
+		}
+		if reflect.DeepEqual(j.sched.ScheduleConfig, sc.ScheduleConfig) {
+			continue
+		}
+		j.sched = sc
+		select {
+		case j.reset <- struct{}{}:
+		default:
+		}
+	}
+	for name, j := range s.jobs {
+		if !keep[name] {
+			close(j.stop)
+			delete(s.jobs, name)
+		}
+	}
+	return nil
+}
+
+// Stop stops firing schedules and cancels the runs still executing.
+func (s *Scheduler) Stop() {
+	s.cancel()
+}
+
+// List returns every schedule with its next firing and last run.
+func (s *Scheduler) List() []Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Status, 0, len(s.order))
+	for _, name := range s.order {
+		out = append(out, s.jobs[name].status())
+	}
+	return out
+}
+
+// Get returns the named schedule's status.
+func (s *Scheduler) Get(name string) (Status, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return Status{}, false
+	}
+	return j.status(), true
+}
+
+// Runs returns the named schedule's recent runs, newest first.
+func (s *Scheduler) Runs(name string) ([]Run, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return nil, false
+	}
+	out := make([]Run, 0, len(j.runs))
+	for i := len(j.runs) - 1; i >= 0; i-- {
+		out = append(out, *j.runs[i])
+	}
+	return out, true
+}
+
+// Trigger fires the named schedule now, subject to its overlap policy, even
+// if it is disabled. It returns the run it started or skipped; a queued
+// firing returns a zero Run.
+func (s *Scheduler) Trigger(name string) (Run, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return Run{}, fmt.Errorf("%w %q", ErrUnknownSchedule, name)
+	}
+	if r := s.fire(j, time.Now()); r != nil {
+		return *r, nil
+	}
+	return Run{}, nil
+}
+
+// loop waits for each firing time of j until the schedule is removed or the
+// scheduler stops. Disabled schedules wait for a config change.
+func (s *Scheduler) loop(j *job) {
+	for {
+		s.mu.Lock()
+		sc := j.sched
+		j.next = time.Time{}
+		if sc.IsEnabled() {
+			j.next = sc.Next(time.Now())
+		}
+		next := j.next
+		s.mu.Unlock()
+
+		var fire <-chan time.Time
+		var timer *time.Timer
+		if !next.IsZero() {
+			timer = time.NewTimer(time.Until(next))
+			fire = timer.C
+		}
+		done := false
+		select {
+		case <-fire:
+			s.mu.Lock()
+			s.fire(j, next)
+			s.mu.Unlock()
+		case <-j.reset:
+		case <-j.stop:
+			done = true
+		case <-s.ctx.Done():
+			done = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if done {
+			return
+		}
+	}
+}
+
+// fire applies the overlap policy and starts, queues or skips a run. It
+// returns the started or skipped run, or nil if it was queued. Caller must
+// hold mu.
+func (s *Scheduler) fire(j *job, at time.Time) *Run {
+	if j.running > 0 {
+		switch j.sched.overlap {
+		case OverlapSkip:
+			now := time.Now().UTC()
+			r := &Run{ScheduledAt: at.UTC(), FinishedAt: &now, State: RunSkipped, Error: "previous run still executing"}
+			j.record(r)
+			slog.Info("scheduled run skipped, previous run still executing", "schedule", j.sched.Name)
+			return r
+		case OverlapQueue:
+			j.queued = append(j.queued, at)
+			return nil
+		}
+	}
+	return s.start(j, at)
+}
+
+// start executes one run of j in the background. Caller must hold mu.
+func (s *Scheduler) start(j *job, at time.Time) *Run {
+	sc := j.sched
+	now := time.Now().UTC()
+	r := &Run{TaskID: newTaskID(), ScheduledAt: at.UTC(), StartedAt: &now, State: RunRunning}
+	j.record(r)
+	j.running++
+
+	req := &pb.TaskRequest{TaskId: r.TaskID, Skill: sc.Skill, Input: sc.Input, Metadata: sc.Metadata}
+	slog.Info("running scheduled task", "schedule", sc.Name, "task_id", r.TaskID, "skill", sc.Skill)
+	go func() {
+		agentName, events, err := s.exec(s.ctx, req, sc.Agent, sc.timeout)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		finished := time.Now().UTC()
+		r.FinishedAt = &finished
+		r.Agent = agentName
+		r.State = RunSucceeded
+		for _, ev := range events {
+			if ev.Type == "result" {
+				r.Result = ev.Payload
+			}
+		}
+		if err != nil {
+			r.State, r.Error = RunFailed, err.Error()
+		} else if n := len(events); n > 0 {
+			switch last := events[n-1]; last.Type {
+			case "error":
+				r.State, r.Error = RunFailed, last.Payload
+			case "timeout":
+				r.State, r.Error = RunTimedOut, last.Payload
+			case "cancelled":
+				r.State = RunCancelled
+			}
+		}
+		if r.State != RunSucceeded {
+			slog.Warn("scheduled task did not succeed", "schedule", sc.Name, "task_id", r.TaskID, "state", r.State, "error", r.Error)
+		}
+
+		j.running--
+		if len(j.queued) > 0 && s.ctx.Err() == nil && s.jobs[sc.Name] == j {
+			next := j.queued[0]
+			j.queued = j.queued[1:]
+			s.start(j, next)
+		}
+	}()
+	return r
+}
+
+// record adds a run to the history, dropping the oldest beyond historyLimit.
+func (j *job) record(r *Run) {
+	j.runs = append(j.runs, r)
+	if n := len(j.runs) - historyLimit; n > 0 {
+		j.runs = append(j.runs[:0], j.runs[n:]...)
+	}
+}
+
+func (j *job) status() Status {
+	st := Status{ScheduleConfig: j.sched.ScheduleConfig, Running: j.running, Queued: len(j.queued)}
+	if !j.next.IsZero() {
+		next := j.next
+		st.NextRun = &next
+	}
+	if n := len(j.runs); n > 0 {
+		last := *j.runs[n-1]
+		st.LastRun = &last
+	}
+	return st
+}
+
+func newTaskID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("task-%x", b)
+}
@@ -3,12 +3,14 @@ package server
 import (
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"idra/internal/agent"
 	"idra/internal/agent/pb"
+	"idra/internal/audit"
 )
 
 func handleAgents(mgr *agent.Manager) http.HandlerFunc {
@@ -23,11 +25,7 @@ func handleAgents(mgr *agent.Manager) http.HandlerFunc {
 
 func handleAgent(mgr *agent.Manager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		name := strings.TrimPrefix(r.URL.Path, "/api/v1/agents/")
-		// Strip trailing path segments (e.g. /tasks)
-		if idx := strings.Index(name, "/"); idx >= 0 {
-			name = name[:idx]
-		}
+		name := agentNameFromPath(r.URL.Path)
 
 		switch r.Method {
 		case http.MethodGet:
@@ -43,7 +41,48 @@ func handleAgent(mgr *agent.Manager) http.HandlerFunc {
 	}
 }
 
-func handleAgentTasks(mgr *agent.Manager) http.HandlerFunc {
+// handleAgentAction serves POST /api/v1/agents/{name}/{start,stop,restart}.
+// All three are idempotent and respond with the resulting agent status.
+func handleAgentAction(mgr *agent.Manager, auditLog *audit.Log, action string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+
+		name := agentNameFromPath(r.URL.Path)
+		if _, ok := mgr.Runner(name); !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "agent not found"})
+			return
+		}
+
+		var (
+			status agent.AgentStatus
+			err    error
+		)
+		switch action {
+		case "start":
+			status, err = mgr.StartAgent(name)
+		case "stop":
+			status, err = mgr.StopAgent(name)
+		case "restart":
+			status, err = mgr.RestartAgent(name)
+		}
+		rec := audit.Record{Action: "agent." + action, Target: name, Summary: "state " + status.State}
+		if err != nil {
+			rec.Summary = "failed: " + err.Error()
+		}
+		appendAudit(auditLog, r, rec)
+		if err != nil {
+			// status.Error carries the failure reason
+			writeJSON(w, http.StatusInternalServerError, status)
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	}
+}
+
+func handleAgentTasks(env *taskEnv) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
@@ -59,11 +98,7 @@ func handleAgentTasks(mgr *agent.Manager) http.HandlerFunc {
 		}
 		agentName := parts[0]
 
-		var body struct {
-			Skill    string            `json:"skill"`
-			Input    string            `json:"input"`
-			Metadata map[string]string `json:"metadata,omitempty"`
-		}
+		var body taskBody
 		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
 			return
@@ -73,25 +108,63 @@ func handleAgentTasks(mgr *agent.Manager) http.HandlerFunc {
 			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "skill is required"})
 			return
 		}
-
-		req := &pb.TaskRequest{
-			TaskId:   generateTaskID(),
-			Skill:    body.Skill,
-			Input:    body.Input,
-			Metadata: body.Metadata,
-		}
-
-		events, err := mgr.RouteTask(r.Context(), agentName, req)
-		if err != nil {
-			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		if body.Timeout < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "timeout must not be negative"})
 			return
 		}
 
-		writeJSON(w, http.StatusOK, map[string]any{
-			"task_id": req.TaskId,
-			"events":  events,
-		})
+		submitTask(w, r, env, agentName, body)
+	}
+}
+
+// taskBody is the JSON body of a task submission.
+type taskBody struct {
+	Skill    string            `json:"skill"`
+	Input    string            `json:"input"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Timeout  agent.Duration    `json:"timeout,omitempty"` // capped by the agent's per-skill maximum
+
+	CallbackURL string `json:"callback_url,omitempty"` // POSTed the task when it finishes
+	Aggregate   string `json:"aggregate,omitempty"`    // skill broadcasts only
+}
+
+// request builds the gRPC task request with a fresh task ID.
+func (b taskBody) request() *pb.TaskRequest {
+	return &pb.TaskRequest{
+		TaskId:   generateTaskID(),
+		Skill:    b.Skill,
+		Input:    b.Input,
+		Metadata: b.Metadata,
+	}
+}
+
+// taskErrorStatus maps routing errors to HTTP status codes.
+func taskErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, agent.ErrUnknownAgent), errors.Is(err, agent.ErrUnknownSkill):
+		return http.StatusNotFound
+	case errors.Is(err, agent.ErrUnsupportedSkill), errors.Is(err, agent.ErrInvalidPriority):
+		return http.StatusBadRequest
+	case errors.Is(err, agent.ErrNoRunningAgent), errors.Is(err, agent.ErrAgentNotRunning),
+		agent.Classify(err) == agent.ClassUnavailable:
+		return http.StatusServiceUnavailable
+	case errors.Is(err, agent.ErrQueueFull):
+		return http.StatusTooManyRequests
+	case errors.Is(err, agent.ErrTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// agentNameFromPath extracts {name} from /api/v1/agents/{name}[/...].
+func agentNameFromPath(path string) string {
+	name := strings.TrimPrefix(path, "/api/v1/agents/")
+	// Strip trailing path segments (e.g. /tasks)
+	if idx := strings.Index(name, "/"); idx >= 0 {
+		name = name[:idx]
 	}
+	return name
 }
 
 func generateTaskID() string {
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"idra/internal/agent"
+)
+
+func TestTaskErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{fmt.Errorf("%w: python-summarizer", agent.ErrQueueFull), http.StatusTooManyRequests},
+		{fmt.Errorf("%w for skill %q", agent.ErrQueueFull, "summarize"), http.StatusTooManyRequests},
+		{fmt.Errorf("%w %q (want high, normal or low)", agent.ErrInvalidPriority, "urgent"), http.StatusBadRequest},
+		{agent.ErrUnsupportedSkill, http.StatusBadRequest},
+		{agent.ErrUnknownAgent, http.StatusNotFound},
+		{agent.ErrUnknownSkill, http.StatusNotFound},
+		{agent.ErrAgentNotRunning, http.StatusServiceUnavailable},
+		{status.Error(codes.Unavailable, "connection refused"), http.StatusServiceUnavailable},
+		{fmt.Errorf("%w: no result within 1s", agent.ErrTimeout), http.StatusGatewayTimeout},
+		{errors.New("boom"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			if got := taskErrorStatus(tt.err); got != tt.want {
+				t.Errorf("taskErrorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"idra/internal/audit"
+	"idra/internal/config"
+)
+
+// appendAudit records an action by r's caller. The action has already
+// happened, so a failed write is logged rather than returned.
+func appendAudit(log *audit.Log, r *http.Request, rec audit.Record) {
+	rec.Actor = identityFrom(r.Context()).Name
+	rec.Remote = r.RemoteAddr
+	if _, err := log.Append(rec); err != nil {
+		slog.Error("audit log append failed", "action", rec.Action, "error", err)
+	}
+}
+
+// configDiff returns the changes between two configs, redacted as for
+// callers without config:write.
+func configDiff(before, after config.Config) []audit.Change {
+	changes, err := audit.Diff(redactConfig(before), redactConfig(after))
+	if err != nil {
+		slog.Error("audit diff failed", "error", err)
+	}
+	return changes
+}
+
+// handleAudit serves GET /api/v1/audit: audit records, most recent first,
+// filtered by actor, action (or an action prefix like "agent"), target,
+// since, until and limit (default 100).
+func handleAudit(log *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		since, err := parseTime(q.Get("since"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "since " + err.Error()})
+			return
+		}
+		until, err := parseTime(q.Get("until"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "until " + err.Error()})
+			return
+		}
+		limit := 100
+		if v := q.Get("limit"); v != "" {
+			if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive integer"})
+				return
+			}
+		}
+		records, err := log.Query(audit.Filter{
+			Actor:  q.Get("actor"),
+			Action: q.Get("action"),
+			Target: q.Get("target"),
+			Since:  since,
+			Until:  until,
+			Limit:  limit,
+		})
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, records)
+	}
+}
+
+// handleAuditVerify serves GET /api/v1/audit/verify: it checks the hash
+// chain of the whole log.
+func handleAuditVerify(log *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+		n, err := log.Verify()
+		if err != nil {
+			writeJSON(w, http.StatusOK, map[string]any{"ok": false, "records": n, "error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "records": n})
+	}
+}
+
+// taskSummary describes a task submission for the audit log.
+func taskSummary(skill, agentName string) string {
+	if agentName == "" {
+		return fmt.Sprintf("skill %q", skill)
+	}
+	return fmt.Sprintf("skill %q on agent %q", skill, agentName)
+}
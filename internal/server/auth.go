@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"idra/internal/config"
+)
+
+// identity is the caller of an API request: the token it authenticated
+// with and what that token may do.
+type identity struct {
+	Name   string // token name; "admin" for the bearer token, "web-ui" for a login-link session
+	Scopes []string
+	Skills []string // skills it may run tasks for; empty = all
+}
+
+func (id identity) can(scope string) bool { return slices.Contains(id.Scopes, scope) }
+
+func (id identity) canRun(skill string) bool {
+	return len(id.Skills) == 0 || slices.Contains(id.Skills, skill)
+}
+
+// canRunAll reports whether the token may run every one of skills.
+func (id identity) canRunAll(skills []string) bool {
+	for _, s := range skills {
+		if !id.canRun(s) {
+			return false
+		}
+	}
+	return true
+}
+
+type identityKey struct{}
+
+// identityFrom returns the caller of a request that passed authMiddleware.
+func identityFrom(ctx context.Context) identity {
+	id, _ := ctx.Value(identityKey{}).(identity)
+	return id
+}
+
+// authConfig returns the config that tokens and sessions are checked
+// against. Tests replace it.
+var authConfig = config.Get
+
+// authenticate resolves the caller of r: the admin bearer token from
+// config.json or a named API token in the Authorization header, or else a
+// web UI session cookie. The config is read on every request, so a rotated
+// or revoked token takes effect immediately. s is the session, if any.
+func authenticate(r *http.Request) (id identity, s *session, err error) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		id, err := resolveToken(token)
+		return id, nil, err
+	}
+
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return identity{}, nil, fmt.Errorf("unauthorized")
+	}
+	s = sessions.get(c.Value)
+	if s == nil {
+		return identity{}, nil, fmt.Errorf("unauthorized")
+	}
+	// An admin session lasts as long as the bearer token it was opened
+	// with: it ends on rotation, after the grace period if there is one.
+	cfg := authConfig()
+	if s.token == "" {
+		if !isAdminHash(cfg, s.tokenHash) {
+			sessions.close(c.Value)
+			return identity{}, nil, fmt.Errorf("unauthorized")
+		}
+		return s.id, s, nil
+	}
+	// A session opened with an API token follows the token's current
+	// scopes, and ends when it is revoked or expires.
+	i := slices.IndexFunc(cfg.Tokens, func(t config.TokenConfig) bool { return t.Name == s.token && t.Hash == s.tokenHash })
+	if i < 0 || cfg.Tokens[i].Expired() {
+		sessions.close(c.Value)
+		return identity{}, nil, fmt.Errorf("unauthorized")
+	}
+	t := cfg.Tokens[i]
+	return identity{Name: t.Name, Scopes: t.Scopes, Skills: t.Skills}, s, nil
+}
+
+// resolveToken returns the identity of a bearer token: the admin token, the
+// previous admin token during its grace period, or a named API token.
+func resolveToken(token string) (identity, error) {
+	cfg := authConfig()
+	if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.BearerToken)) == 1 {
+		return identity{Name: config.AdminName, Scopes: config.Scopes}, nil
+	}
+	hash := config.HashToken(token)
+	if isAdminHash(cfg, hash) {
+		return identity{Name: config.AdminName, Scopes: config.Scopes}, nil
+	}
+	for _, t := range cfg.Tokens {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(t.Hash)) != 1 {
+			continue
+		}
+		if t.Expired() {
+			return identity{}, fmt.Errorf("token expired")
+		}
+		return identity{Name: t.Name, Scopes: t.Scopes, Skills: t.Skills}, nil
+	}
+	return identity{}, fmt.Errorf("unauthorized")
+}
+
+// isAdminHash reports whether hash is the hash of the bearer token, or of
+// the previous bearer token during its grace period after a rotation.
+func isAdminHash(cfg config.Config, hash string) bool {
+	if subtle.ConstantTimeCompare([]byte(hash), []byte(config.HashToken(cfg.BearerToken))) == 1 {
+		return true
+	}
+	old := cfg.RetiredToken
+	return old != nil && time.Now().Before(old.ExpiresAt) &&
+		subtle.ConstantTimeCompare([]byte(hash), []byte(old.Hash)) == 1
+}
+
+// authMiddleware checks the caller's token for API endpoints. GET and HEAD
+// requests need the read scope, other methods the given scope, and, from a
+// web UI session, the session's CSRF token. Health endpoint is excluded so
+// monitoring tools can probe without auth.
+func authMiddleware(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, s, err := authenticate(r)
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			return
+		}
+		need := scope
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			need = config.ScopeRead
+		} else if s != nil && !s.checkCSRF(r) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "missing or invalid CSRF token"})
+			return
+		}
+		if !id.can(need) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": fmt.Sprintf("token %q lacks scope %q", id.Name, need)})
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	}
+}
+
+// allowSkill reports whether the caller may run tasks of skill, answering
+// 403 if not.
+func allowSkill(w http.ResponseWriter, r *http.Request, skill string) bool {
+	if id := identityFrom(r.Context()); !id.canRun(skill) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": fmt.Sprintf("token %q may not run skill %q", id.Name, skill)})
+		return false
+	}
+	return true
+}
+
+// allowSkills is allowSkill for records spanning several skills, such as
+// batches and workflow runs: the caller must be able to run all of them.
+func allowSkills(w http.ResponseWriter, r *http.Request, skills []string) bool {
+	for _, s := range skills {
+		if !allowSkill(w, r, s) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"idra/internal/config"
+)
+
+// useConfig makes cfg the config tokens are checked against for the test.
+func useConfig(t *testing.T, cfg config.Config) {
+	t.Helper()
+	authConfig = func() config.Config { return cfg }
+	t.Cleanup(func() { authConfig = config.Get })
+}
+
+func TestCanRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		skills []string
+		skill  string
+		want   bool
+	}{
+		{"no allow-list", nil, "summarize", true},
+		{"allowed", []string{"summarize", "translate"}, "translate", true},
+		{"not allowed", []string{"summarize"}, "translate", false},
+		{"no prefix match", []string{"sum"}, "summarize", false},
+		{"empty skill", []string{"summarize"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := identity{Name: "ci", Skills: tt.skills}
+			if got := id.canRun(tt.skill); got != tt.want {
+				t.Errorf("canRun(%q) = %v, want %v", tt.skill, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsAdminHash(t *testing.T) {
+	cfg := config.Config{BearerToken: "new-token"}
+	retired := func(token string, left time.Duration) *config.RetiredToken {
+		return &config.RetiredToken{Hash: config.HashToken(token), ExpiresAt: time.Now().Add(left)}
+	}
+	tests := []struct {
+		name    string
+		retired *config.RetiredToken
+		token   string
+		want    bool
+	}{
+		{"current token", nil, "new-token", true},
+		{"current token during grace", retired("old-token", time.Hour), "new-token", true},
+		{"old token during grace", retired("old-token", time.Hour), "old-token", true},
+		{"old token after grace", retired("old-token", -time.Second), "old-token", false},
+		{"old token without grace", nil, "old-token", false},
+		{"unknown token", retired("old-token", time.Hour), "other-token", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.RetiredToken = tt.retired
+			if got := isAdminHash(cfg, config.HashToken(tt.token)); got != tt.want {
+				t.Errorf("isAdminHash(%q) = %v, want %v", tt.token, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareScopes(t *testing.T) {
+	token := func(name string, scopes ...string) config.TokenConfig {
+		return config.TokenConfig{Name: name, Hash: config.HashToken(name + "-token"), Scopes: scopes}
+	}
+	expired := token("old", config.Scopes...)
+	expired.ExpiresAt = new(time.Time)
+	useConfig(t, config.Config{BearerToken: "admin-token", Tokens: []config.TokenConfig{
+		token("reader", config.ScopeRead),
+		token("runner", config.ScopeRead, config.ScopeTasksExecute),
+		token("writer", config.ScopeTasksExecute),
+		expired,
+	}})
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		want   int
+	}{
+		{"admin reads", "admin-token", http.MethodGet, http.StatusOK},
+		{"admin writes", "admin-token", http.MethodPost, http.StatusOK},
+		{"read scope reads", "reader-token", http.MethodGet, http.StatusOK},
+		{"read scope heads", "reader-token", http.MethodHead, http.StatusOK},
+		{"read scope may not post", "reader-token", http.MethodPost, http.StatusForbidden},
+		{"read scope may not delete", "reader-token", http.MethodDelete, http.StatusForbidden},
+		{"endpoint scope posts", "runner-token", http.MethodPost, http.StatusOK},
+		{"endpoint scope deletes", "runner-token", http.MethodDelete, http.StatusOK},
+		{"endpoint scope without read may not get", "writer-token", http.MethodGet, http.StatusForbidden},
+		{"endpoint scope without read posts", "writer-token", http.MethodPost, http.StatusOK},
+		{"expired token", "old-token", http.MethodGet, http.StatusUnauthorized},
+		{"unknown token", "nobody-token", http.MethodGet, http.StatusUnauthorized},
+		{"no token", "", http.MethodGet, http.StatusUnauthorized},
+	}
+	h := authMiddleware(config.ScopeTasksExecute, func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v1/tasks", nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tt.want {
+				t.Errorf("%s with %q = %d, want %d", tt.method, tt.token, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthMiddlewareCSRF(t *testing.T) {
+	useConfig(t, config.Config{BearerToken: "admin-token"})
+	value, s := sessions.open(identity{Name: config.WebUIName, Scopes: config.Scopes}, "", config.HashToken("admin-token"))
+	t.Cleanup(func() { sessions.close(value) })
+
+	tests := []struct {
+		name   string
+		method string
+		cookie bool
+		csrf   string
+		bearer bool
+		want   int
+	}{
+		{"session reads without CSRF token", http.MethodGet, true, "", false, http.StatusOK},
+		{"session posts with CSRF token", http.MethodPost, true, s.csrf, false, http.StatusOK},
+		{"session posts without CSRF token", http.MethodPost, true, "", false, http.StatusForbidden},
+		{"session posts with wrong CSRF token", http.MethodPost, true, "forged", false, http.StatusForbidden},
+		{"session deletes without CSRF token", http.MethodDelete, true, "", false, http.StatusForbidden},
+		{"bearer token needs no CSRF token", http.MethodPost, false, "", true, http.StatusOK},
+		{"bearer token wins over the cookie", http.MethodPost, true, "", true, http.StatusOK},
+		{"CSRF token without a session", http.MethodPost, false, s.csrf, false, http.StatusUnauthorized},
+	}
+	h := authMiddleware(config.ScopeTasksExecute, func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v1/tasks", nil)
+			if tt.cookie {
+				r.AddCookie(&http.Cookie{Name: sessionCookie, Value: value})
+			}
+			if tt.csrf != "" {
+				r.Header.Set(csrfHeader, tt.csrf)
+			}
+			if tt.bearer {
+				r.Header.Set("Authorization", "Bearer admin-token")
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"idra/internal/audit"
+	"idra/internal/batch"
+)
+
+const (
+	maxBatchBytes      = 64 << 20
+	defaultConcurrency = 4
+	maxConcurrency     = 64
+)
+
+// handleBatches serves /api/v1/batches: GET lists recent batches, POST
+// starts one from a JSONL upload, either as the raw request body or as the
+// "file" field of a multipart form. Options are query parameters (or form
+// fields): concurrency (default 4), skill for lines that don't name one,
+// and timeout per task.
+func handleBatches(batches *batch.Manager, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			id := identityFrom(r.Context())
+			list := slices.DeleteFunc(batches.List(), func(b batch.Batch) bool { return !id.canRunAll(b.Skills) })
+			writeJSON(w, http.StatusOK, list)
+
+		case http.MethodPost:
+			// The body is read as JSONL whatever its Content-Type (curl
+			// --data-binary sends form-urlencoded), so options come from the
+			// query unless the upload is a multipart form.
+			r.Body = http.MaxBytesReader(w, r.Body, maxBatchBytes)
+			var src io.Reader = r.Body
+			opt := r.URL.Query().Get
+			if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+				f, _, err := r.FormFile("file")
+				if err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file: " + err.Error()})
+					return
+				}
+				defer f.Close()
+				src = f
+				opt = r.FormValue
+			}
+
+			concurrency, timeout, err := parseBatchOptions(opt)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			lines, errs, err := batch.Parse(src, opt("skill"))
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+
+			// Lines for skills the token may not run fail like malformed ones.
+			id := identityFrom(r.Context())
+			for i, l := range lines {
+				if errs[i] == nil && !id.canRun(l.Skill) {
+					errs[i] = fmt.Errorf("token %q may not run skill %q", id.Name, l.Skill)
+				}
+			}
+
+			b := batches.Start(lines, errs, concurrency, timeout)
+			appendAudit(auditLog, r, audit.Record{Action: "batch.submit", Target: b.ID, Summary: fmt.Sprintf("%d lines, concurrency %d", b.Total, concurrency)})
+			w.Header().Set("Location", "/api/v1/batches/"+b.ID)
+			writeJSON(w, http.StatusAccepted, b)
+
+		default:
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// parseBatchOptions reads the concurrency and timeout of a batch request.
+func parseBatchOptions(opt func(string) string) (int, time.Duration, error) {
+	concurrency := defaultConcurrency
+	if v := opt("concurrency"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxConcurrency {
+			return 0, 0, fmt.Errorf("concurrency must be between 1 and %d", maxConcurrency)
+		}
+		concurrency = n
+	}
+	var timeout time.Duration
+	if v := opt("timeout"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return 0, 0, fmt.Errorf("timeout must be a duration such as \"30s\"")
+		}
+		timeout = d
+	}
+	return concurrency, timeout, nil
+}
+
+// handleBatch serves /api/v1/batches/{id}: GET returns the batch's progress
+// and DELETE cancels it. GET .../results downloads the per-line results as
+// JSONL in input order; lines still running are reported as such.
+func handleBatch(batches *batch.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/api/v1/batches/")
+		id, action, _ := strings.Cut(path, "/")
+		b, ok := batches.Get(id)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "batch not found"})
+			return
+		}
+		if !allowSkills(w, r, b.Skills) {
+			return
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, b)
+
+		case action == "" && r.Method == http.MethodDelete:
+			batches.Cancel(id)
+			writeJSON(w, http.StatusAccepted, map[string]string{"id": id, "state": "cancelling"})
+
+		case action == "results" && r.Method == http.MethodGet:
+			results, _ := batches.Results(id)
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.Header().Set("Content-Disposition", `attachment; filename="`+id+`-results.jsonl"`)
+			batch.WriteResults(w, results)
+
+		case action == "" || action == "results":
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"net/http"
+
+	"idra/internal/agent"
+)
+
+// handleCache serves /api/v1/cache: GET reports the result cache of every
+// cached skill (entries, hits, misses, evictions), DELETE drops the cached
+// results of the skill in the query, or all of them.
+func handleCache(mgr *agent.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, mgr.CacheStats())
+		case http.MethodDelete:
+			n := mgr.PurgeCache(r.URL.Query().Get("skill"))
+			writeJSON(w, http.StatusOK, map[string]int{"purged": n})
+		default:
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strings"
+
+	"idra/internal/agent/pb"
+	"idra/internal/audit"
+	"idra/internal/task"
+)
+
+// redriveBody is the optional JSON body of a re-drive request.
+type redriveBody struct {
+	Agent string `json:"agent,omitempty"` // run on this agent instead of the original target
+}
+
+// decodeRedrive reads a re-drive body; an empty body keeps the original
+// target.
+func decodeRedrive(r *http.Request) (redriveBody, error) {
+	var body redriveBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+		return body, err
+	}
+	return body, nil
+}
+
+// handleDeadLetters serves GET /api/v1/dlq: tasks that failed or timed out
+// after all retries, most recently failed first, with the same filters as
+// GET /api/v1/tasks.
+func handleDeadLetters(dlq *task.DeadLetters) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+		f, err := parseFilter(r.URL.Query())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		f.Skills = identityFrom(r.Context()).Skills
+		writeJSON(w, http.StatusOK, dlq.List(f))
+	}
+}
+
+// handleDeadLetter serves /api/v1/dlq/{id}: GET returns the dead letter and
+// DELETE discards it. POST /api/v1/dlq/{id}/redrive runs the task again.
+func handleDeadLetter(env *taskEnv) http.HandlerFunc {
+	dlq := env.dlq
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/v1/dlq/")
+		id, redrive := strings.CutSuffix(id, "/redrive")
+		d, ok := dlq.Get(id)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "dead letter not found"})
+			return
+		}
+		if !allowSkill(w, r, d.Request.Skill) {
+			return
+		}
+
+		switch {
+		case redrive && r.Method == http.MethodPost:
+			body, err := decodeRedrive(r)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			taskID, err := redriveTask(env, d, body.Agent)
+			if err != nil {
+				writeJSON(w, taskErrorStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			appendAudit(env.audit, r, audit.Record{Action: "task.redrive", Target: taskID, Summary: "redriven from " + d.TaskID})
+			w.Header().Set("Location", "/api/v1/tasks/"+taskID)
+			writeJSON(w, http.StatusAccepted, map[string]any{
+				"task_id":       taskID,
+				"redriven_from": d.TaskID,
+				"state":         task.StateQueued,
+			})
+
+		case !redrive && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, d)
+
+		case !redrive && r.Method == http.MethodDelete:
+			if _, err := dlq.Remove(id); err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleRedriveAll serves POST /api/v1/dlq/redrive: re-drives every dead
+// letter matching the query filters, optionally to another agent. Tasks that
+// can't be submitted stay in the queue and are reported with their error.
+func handleRedriveAll(env *taskEnv) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+		f, err := parseFilter(r.URL.Query())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if r.URL.Query().Get("limit") == "" {
+			f.Limit = 0
+		}
+		body, err := decodeRedrive(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		redriven := make([]map[string]string, 0)
+		failed := make([]map[string]string, 0)
+		id := identityFrom(r.Context())
+		for _, d := range env.dlq.List(f) {
+			if !id.canRun(d.Request.Skill) {
+				failed = append(failed, map[string]string{"task_id": d.TaskID, "error": fmt.Sprintf("token %q may not run skill %q", id.Name, d.Request.Skill)})
+				continue
+			}
+			taskID, err := redriveTask(env, d, body.Agent)
+			if err != nil {
+				failed = append(failed, map[string]string{"task_id": d.TaskID, "error": err.Error()})
+				continue
+			}
+			redriven = append(redriven, map[string]string{"task_id": taskID, "redriven_from": d.TaskID})
+			appendAudit(env.audit, r, audit.Record{Action: "task.redrive", Target: taskID, Summary: "redriven from " + d.TaskID})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{
+			"redriven": redriven,
+			"failed":   failed,
+		})
+	}
+}
+
+// redriveTask submits a dead letter's request again as a new background
+// task on agentName, or on the original target if agentName is empty, and
+// removes it from the queue. It returns the new task's ID. If the task fails
+// again it is dead-lettered under that ID.
+func redriveTask(env *taskEnv, d task.DeadLetter, agentName string) (string, error) {
+	mgr := env.mgr
+	if agentName == "" {
+		agentName = d.Agent
+	}
+	req := &pb.TaskRequest{
+		TaskId:   generateTaskID(),
+		Skill:    d.Request.Skill,
+		Input:    d.Request.Input,
+		Metadata: maps.Clone(d.Request.Metadata),
+	}
+	if err := mgr.CheckRoute(agentName, req.Skill); err != nil {
+		return "", err
+	}
+	if err := mgr.CheckCapacity(agentName, req.Skill); err != nil {
+		return "", err
+	}
+	if _, err := env.dlq.Remove(d.TaskID); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	env.tasks.Create(req, agentName, "", cancel)
+	if d.Callback != "" {
+		env.tasks.SetCallback(req.TaskId, d.Callback)
+	}
+	slog.Info("re-driving dead-lettered task", "task_id", d.TaskID, "new_task_id", req.TaskId, "agent", agentName, "skill", req.Skill)
+	go func() {
+		defer cancel()
+		trackTask(ctx, env, req, agentName, d.Timeout.Std(), routeTask(mgr, req, agentName, d.Timeout.Std()),
+			func(*pb.TaskEvent) error { return nil })
+	}()
+	return req.TaskId, nil
+}
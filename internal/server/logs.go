@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"idra/internal/agent"
+)
+
+// handleAgentLogs serves GET /api/v1/agents/{name}/logs.
+//
+// Query parameters:
+//
+//	tail=N          only the last N matching lines
+//	since=T         RFC 3339 timestamp, or a duration like "10m" meaning "that long ago"
+//	filter=RE       only lines matching the regular expression (RE2 syntax)
+//	stream=S        only "stdout" or "stderr"
+//	follow=true     keep the connection open and stream new lines as NDJSON
+func handleAgentLogs(mgr *agent.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+
+		runner, ok := mgr.Runner(agentNameFromPath(r.URL.Path))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "agent not found"})
+			return
+		}
+
+		q, err := parseLogQuery(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+
+		if r.URL.Query().Get("follow") != "true" {
+			lines := runner.Logs().Lines(q)
+			writeJSON(w, http.StatusOK, map[string]any{
+				"agent": runner.Name(),
+				"lines": lines,
+			})
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "streaming not supported"})
+			return
+		}
+
+		// Subscribe before reading the backlog so no line falls in between.
+		ch, unsubscribe := runner.Logs().Subscribe()
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		enc := json.NewEncoder(w)
+		var last time.Time
+		for _, l := range runner.Logs().Lines(q) {
+			enc.Encode(l)
+			last = l.Time
+		}
+		flusher.Flush()
+
+		// The tail limit applies to the backlog only.
+		q.Tail = 0
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case l := <-ch:
+				if !l.Time.After(last) || !q.Match(l) {
+					continue
+				}
+				if err := enc.Encode(l); err != nil {
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func parseLogQuery(r *http.Request) (agent.LogQuery, error) {
+	var q agent.LogQuery
+	params := r.URL.Query()
+
+	if v := params.Get("tail"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("tail must be a non-negative integer")
+		}
+		q.Tail = n
+	}
+
+	if v := params.Get("since"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			q.Since = time.Now().Add(-d)
+		} else if t, err := time.Parse(time.RFC3339, v); err == nil {
+			q.Since = t
+		} else {
+			return q, fmt.Errorf("since must be an RFC 3339 timestamp or a duration")
+		}
+	}
+
+	if v := params.Get("filter"); v != "" {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return q, fmt.Errorf("invalid filter: %w", err)
+		}
+		q.Filter = re
+	}
+
+	switch v := params.Get("stream"); v {
+	case "", "stdout", "stderr":
+		q.Stream = v
+	default:
+		return q, fmt.Errorf("stream must be stdout or stderr")
+	}
+
+	return q, nil
+}
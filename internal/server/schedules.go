@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"idra/internal/agent"
+	"idra/internal/audit"
+	"idra/internal/config"
+	"idra/internal/scheduler"
+)
+
+// validateSchedules checks schedule entries and that their skill can be
+// routed.
+func validateSchedules(mgr *agent.Manager, cfgs []config.ScheduleConfig) error {
+	if err := scheduler.Validate(cfgs); err != nil {
+		return err
+	}
+	for _, sc := range cfgs {
+		if err := mgr.CheckRoute(sc.Agent, sc.Skill); err != nil {
+			return fmt.Errorf("schedule %q: %w", sc.Name, err)
+		}
+		if _, err := agent.ParsePriority(sc.Metadata["priority"]); err != nil {
+			return fmt.Errorf("schedule %q: %w", sc.Name, err)
+		}
+	}
+	return nil
+}
+
+// handleSchedules serves /api/v1/schedules: GET lists the schedules with
+// their next and last runs, POST adds one to config.json.
+func handleSchedules(mgr *agent.Manager, sched *scheduler.Scheduler, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, sched.List())
+
+		case http.MethodPost:
+			var sc config.ScheduleConfig
+			if err := json.NewDecoder(r.Body).Decode(&sc); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if sc.Name == "" || strings.Contains(sc.Name, "/") {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name is required and must not contain '/'"})
+				return
+			}
+			if !allowSkill(w, r, sc.Skill) {
+				return
+			}
+			if err := validateSchedules(mgr, []config.ScheduleConfig{sc}); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			exists := false
+			before := config.Get()
+			updated, err := config.Update(func(c *config.Config) {
+				if slices.ContainsFunc(c.Schedules, func(s config.ScheduleConfig) bool { return s.Name == sc.Name }) {
+					exists = true
+					return
+				}
+				c.Schedules = append(slices.Clone(c.Schedules), sc)
+			})
+			if exists {
+				writeJSON(w, http.StatusConflict, map[string]string{"error": "schedule already exists"})
+				return
+			}
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			appendAudit(auditLog, r, audit.Record{Action: "schedule.create", Target: sc.Name, Diff: configDiff(before, updated)})
+			applySchedules(sched, updated)
+			st, _ := sched.Get(sc.Name)
+			w.Header().Set("Location", "/api/v1/schedules/"+sc.Name)
+			writeJSON(w, http.StatusCreated, st)
+
+		default:
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleSchedule serves /api/v1/schedules/{name}: GET returns the schedule,
+// PUT replaces it and DELETE removes it. GET .../runs lists its recent runs
+// and POST .../run fires it now.
+func handleSchedule(mgr *agent.Manager, sched *scheduler.Scheduler, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/api/v1/schedules/")
+		name, action, _ := strings.Cut(path, "/")
+		st, ok := sched.Get(name)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "schedule not found"})
+			return
+		}
+
+		switch {
+		case action == "" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, st)
+
+		case action == "" && r.Method == http.MethodPut:
+			var sc config.ScheduleConfig
+			if err := json.NewDecoder(r.Body).Decode(&sc); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if sc.Name != "" && sc.Name != name {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name in body does not match path"})
+				return
+			}
+			sc.Name = name
+			if !allowSkill(w, r, st.Skill) || !allowSkill(w, r, sc.Skill) {
+				return
+			}
+			if err := validateSchedules(mgr, []config.ScheduleConfig{sc}); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			before := config.Get()
+			updated, err := config.Update(func(c *config.Config) {
+				c.Schedules = slices.Clone(c.Schedules)
+				if i := slices.IndexFunc(c.Schedules, func(s config.ScheduleConfig) bool { return s.Name == name }); i >= 0 {
+					c.Schedules[i] = sc
+				}
+			})
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			appendAudit(auditLog, r, audit.Record{Action: "schedule.replace", Target: name, Diff: configDiff(before, updated)})
+			applySchedules(sched, updated)
+			st, _ = sched.Get(name)
+			writeJSON(w, http.StatusOK, st)
+
+		case action == "" && r.Method == http.MethodDelete:
+			if !allowSkill(w, r, st.Skill) {
+				return
+			}
+			before := config.Get()
+			updated, err := config.Update(func(c *config.Config) {
+				c.Schedules = slices.DeleteFunc(slices.Clone(c.Schedules), func(s config.ScheduleConfig) bool { return s.Name == name })
+			})
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			appendAudit(auditLog, r, audit.Record{Action: "schedule.delete", Target: name, Diff: configDiff(before, updated)})
+			applySchedules(sched, updated)
+			w.WriteHeader(http.StatusNoContent)
+
+		case action == "runs" && r.Method == http.MethodGet:
+			runs, _ := sched.Runs(name)
+			writeJSON(w, http.StatusOK, runs)
+
+		case action == "run" && r.Method == http.MethodPost:
+			if !allowSkill(w, r, st.Skill) {
+				return
+			}
+			run, err := sched.Trigger(name)
+			if errors.Is(err, scheduler.ErrUnknownSchedule) {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+				return
+			}
+			appendAudit(auditLog, r, audit.Record{Action: "schedule.run", Target: name, Summary: taskSummary(st.Skill, st.Agent)})
+			if run.TaskID == "" && run.State == "" {
+				writeJSON(w, http.StatusAccepted, map[string]string{"state": "queued"})
+				return
+			}
+			writeJSON(w, http.StatusAccepted, run)
+
+		case action == "" || action == "runs" || action == "run":
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+func applySchedules(sched *scheduler.Scheduler, c config.Config) {
+	if sched == nil {
+		return
+	}
+	if err := sched.Apply(c.Schedules); err != nil {
+		slog.Error("failed to apply schedules", "error", err)
+	}
+}
@@ -2,21 +2,35 @@ package server
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/fs"
 	"log/slog"
 	"net"
 	"net/http"
+	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 	"time"
 
 	"idra/internal/agent"
+	"idra/internal/audit"
+	"idra/internal/batch"
 	"idra/internal/config"
+	"idra/internal/platform"
+	"idra/internal/scheduler"
+	"idra/internal/task"
+	"idra/internal/webhook"
+	"idra/internal/workflow"
 	"idra/web"
 )
 
+// taskRetention is how many tasks the task history keeps on disk.
+const taskRetention = 10000
+
 var (
 	Version   = "dev"
 	startTime = time.Now()
@@ -25,9 +39,18 @@ var (
 type Server struct {
 	httpServer *http.Server
 	addr       string
+	mgr        *agent.Manager
+	taskStore  *task.Store
+	dlq        *task.DeadLetters
+	sched      *scheduler.Scheduler
+	audit      *audit.Log
 }
 
 func New(cfg config.Config, mgr *agent.Manager) (*Server, error) {
+	addr, err := resolveAddr(cfg.Port)
+	if err != nil {
+		return nil, err
+	}
 	mux := http.NewServeMux()
 
 	// Static files (embedded)
@@ -52,36 +75,148 @@ func New(cfg config.Config, mgr *agent.Manager) (*Server, error) {
 		w.Write(data)
 	})
 
+	// Web UI sign-in: a one-time link from LoginURL, or a token entered in the UI
+	mux.HandleFunc("/login", handleLogin)
+	mux.HandleFunc("/api/v1/session", handleSession(addr))
+
 	// API routes
 	mux.HandleFunc("/api/v1/health", handleHealth)
-	mux.HandleFunc("/api/v1/config", authMiddleware(handleConfig))
-	mux.HandleFunc("/api/v1/status", authMiddleware(handleStatus))
+	mux.HandleFunc("/api/v1/status", authMiddleware(config.ScopeRead, handleStatus))
+
+	// Audit log of config changes, agent actions and task submissions:
+	// /api/v1/audit and /api/v1/audit/verify
+	auditLog, err := audit.Open(platform.DataDir())
+	if err != nil {
+		slog.Error("audit log unavailable, keeping it in memory", "error", err)
+		auditLog, _ = audit.Open("")
+	}
+	mux.HandleFunc("/api/v1/audit", authMiddleware(config.ScopeRead, handleAudit(auditLog)))
+	mux.HandleFunc("/api/v1/audit/verify", authMiddleware(config.ScopeRead, handleAuditVerify(auditLog)))
 
 	// Agent API routes
+	var taskStore *task.Store
+	var dlq *task.DeadLetters
+	var sched *scheduler.Scheduler
 	if mgr != nil {
-		mux.HandleFunc("/api/v1/agents", authMiddleware(handleAgents(mgr)))
-		// Use a path-based router: /api/v1/agents/{name} and /api/v1/agents/{name}/tasks
-		mux.HandleFunc("/api/v1/agents/", authMiddleware(func(w http.ResponseWriter, r *http.Request) {
-			if strings.HasSuffix(r.URL.Path, "/tasks") {
-				handleAgentTasks(mgr)(w, r)
-			} else {
-				handleAgent(mgr)(w, r)
+		// Task history and the dead-letter queue survive restarts; without
+		// them tasks are kept in memory.
+		tasksDir := filepath.Join(platform.DataDir(), "tasks")
+		taskStore, err = task.OpenStore(tasksDir, taskRetention)
+		if err != nil {
+			slog.Error("task store unavailable, keeping task history in memory", "error", err)
+		}
+		tasks := task.NewTracker(1000, taskStore)
+		dlq, err = task.OpenDeadLetters(tasksDir)
+		if err != nil {
+			slog.Error("dead-letter log unavailable, keeping dead letters in memory", "error", err)
+			dlq, _ = task.OpenDeadLetters("")
+		}
+		// Results of skills cached with "persist" survive restarts.
+		if err := mgr.LoadCache(filepath.Join(platform.DataDir(), "cache.json")); err != nil {
+			slog.Error("result cache unavailable, starting empty", "error", err)
+		}
+
+		env := &taskEnv{mgr: mgr, tasks: tasks, dlq: dlq, webhooks: webhook.NewSender(), audit: auditLog}
+
+		mux.HandleFunc("/api/v1/agents", authMiddleware(config.ScopeRead, handleAgents(mgr)))
+		// Use a path-based router: /api/v1/agents/{name}, /api/v1/agents/{name}/tasks,
+		// /api/v1/agents/{name}/logs and /api/v1/agents/{name}/{start,stop,restart}
+		mux.HandleFunc("/api/v1/agents/", func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case strings.HasSuffix(r.URL.Path, "/tasks"):
+				authMiddleware(config.ScopeTasksExecute, handleAgentTasks(env))(w, r)
+			case strings.HasSuffix(r.URL.Path, "/logs"):
+				authMiddleware(config.ScopeRead, handleAgentLogs(mgr))(w, r)
+			case strings.HasSuffix(r.URL.Path, "/start"):
+				authMiddleware(config.ScopeAgentsManage, handleAgentAction(mgr, auditLog, "start"))(w, r)
+			case strings.HasSuffix(r.URL.Path, "/stop"):
+				authMiddleware(config.ScopeAgentsManage, handleAgentAction(mgr, auditLog, "stop"))(w, r)
+			case strings.HasSuffix(r.URL.Path, "/restart"):
+				authMiddleware(config.ScopeAgentsManage, handleAgentAction(mgr, auditLog, "restart"))(w, r)
+			default:
+				authMiddleware(config.ScopeRead, handleAgent(mgr))(w, r)
+			}
+		})
+
+		// Skill routing: /api/v1/skills and /api/v1/skills/{skill}/tasks
+		mux.HandleFunc("/api/v1/skills", authMiddleware(config.ScopeRead, handleSkills(mgr)))
+		mux.HandleFunc("/api/v1/skills/", authMiddleware(config.ScopeTasksExecute, func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasSuffix(r.URL.Path, "/tasks") {
+				http.NotFound(w, r)
+				return
 			}
+			handleSkillTasks(env)(w, r)
 		}))
-	}
 
-	addr, err := resolveAddr(cfg.Port)
-	if err != nil {
-		return nil, err
+		// Task records: /api/v1/tasks and /api/v1/tasks/{id} (GET, DELETE to cancel)
+		mux.HandleFunc("/api/v1/tasks", authMiddleware(config.ScopeRead, handleTasks(tasks)))
+		mux.HandleFunc("/api/v1/tasks/", authMiddleware(config.ScopeTasksExecute, handleTask(mgr, tasks)))
+
+		// Workflows: /api/v1/workflows, /api/v1/workflows/{name} and
+		// /api/v1/workflows/{name}/runs[/{id}]. Definitions live next to
+		// config.json.
+		workflowsDir := filepath.Join(platform.ConfigDir(), "workflows")
+		engine := workflow.NewEngine(taskExecutor(env), 1000)
+		mux.HandleFunc("/api/v1/workflows", authMiddleware(config.ScopeRead, handleWorkflows(workflowsDir)))
+		mux.HandleFunc("/api/v1/workflows/", authMiddleware(config.ScopeTasksExecute, handleWorkflow(mgr, engine, auditLog, workflowsDir)))
+
+		// Schedules: /api/v1/schedules and /api/v1/schedules/{name}[/runs,/run].
+		// Entries are stored in config.json.
+		sched = scheduler.New(scheduler.Executor(taskExecutor(env)))
+		if err := validateSchedules(mgr, cfg.Schedules); err != nil {
+			slog.Error("invalid schedules, none will fire", "error", err)
+		} else {
+			applySchedules(sched, cfg)
+		}
+		mux.HandleFunc("/api/v1/schedules", authMiddleware(config.ScopeConfigWrite, handleSchedules(mgr, sched, auditLog)))
+		mux.HandleFunc("/api/v1/schedules/", func(w http.ResponseWriter, r *http.Request) {
+			// Firing a schedule runs a task; everything else edits config.json.
+			scope := config.ScopeConfigWrite
+			if strings.HasSuffix(r.URL.Path, "/run") {
+				scope = config.ScopeTasksExecute
+			}
+			authMiddleware(scope, handleSchedule(mgr, sched, auditLog))(w, r)
+		})
+
+		// Batches: /api/v1/batches and /api/v1/batches/{id}[/results]
+		batches := batch.NewManager(batch.Executor(taskExecutor(env)), 100)
+		mux.HandleFunc("/api/v1/batches", authMiddleware(config.ScopeTasksExecute, handleBatches(batches, auditLog)))
+		mux.HandleFunc("/api/v1/batches/", authMiddleware(config.ScopeTasksExecute, handleBatch(batches)))
+
+		// Result cache: /api/v1/cache (GET stats, DELETE to purge)
+		mux.HandleFunc("/api/v1/cache", authMiddleware(config.ScopeAgentsManage, handleCache(mgr)))
+
+		// Dead-letter queue: /api/v1/dlq, /api/v1/dlq/redrive and
+		// /api/v1/dlq/{id} (GET, DELETE, POST .../redrive)
+		mux.HandleFunc("/api/v1/dlq", authMiddleware(config.ScopeRead, handleDeadLetters(dlq)))
+		mux.HandleFunc("/api/v1/dlq/", authMiddleware(config.ScopeTasksExecute, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/api/v1/dlq/redrive" {
+				handleRedriveAll(env)(w, r)
+				return
+			}
+			handleDeadLetter(env)(w, r)
+		}))
 	}
 
+	mux.HandleFunc("/api/v1/config", authMiddleware(config.ScopeConfigWrite, handleConfig(mgr, sched, auditLog)))
+
+	// API tokens: /api/v1/tokens and /api/v1/tokens/{name}
+	mux.HandleFunc("/api/v1/tokens", authMiddleware(config.ScopeConfigWrite, handleTokens(auditLog)))
+	mux.HandleFunc("/api/v1/tokens/", authMiddleware(config.ScopeConfigWrite, handleToken(auditLog)))
+	mux.HandleFunc("/api/v1/bearer-token/rotate", authMiddleware(config.ScopeConfigWrite, handleRotateBearer(auditLog)))
+
 	return &Server{
 		httpServer: &http.Server{
 			Addr:              addr,
 			Handler:           mux,
 			ReadHeaderTimeout: 10 * time.Second,
 		},
-		addr: addr,
+		addr:      addr,
+		mgr:       mgr,
+		taskStore: taskStore,
+		dlq:       dlq,
+		sched:     sched,
+		audit:     auditLog,
 	}, nil
 }
 
@@ -93,7 +228,23 @@ func (s *Server) ListenAndServe() error {
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.httpServer.Shutdown(ctx)
+	if s.sched != nil {
+		s.sched.Stop()
+	}
+	err := s.httpServer.Shutdown(ctx)
+	if s.mgr != nil {
+		if err := s.mgr.SaveCache(); err != nil {
+			slog.Error("failed to save result cache", "error", err)
+		}
+	}
+	if s.taskStore != nil {
+		s.taskStore.Close()
+	}
+	if s.dlq != nil {
+		s.dlq.Close()
+	}
+	s.audit.Close()
+	return err
 }
 
 // resolveAddr tries the configured port, then falls back to 7601-7609.
@@ -121,28 +272,6 @@ func resolveAddr(port int) (string, error) {
 	return "", fmt.Errorf("no available port (tried %d and 7601-7609)", port)
 }
 
-// authMiddleware checks the Bearer token for API endpoints.
-// Health endpoint is excluded so monitoring tools can probe without auth.
-func authMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		cfg := config.Get()
-		auth := r.Header.Get("Authorization")
-		token := strings.TrimPrefix(auth, "Bearer ")
-
-		// Allow requests from the web UI (same-origin, no auth header) via
-		// Referer check — the UI is served from localhost only.
-		referer := r.Header.Get("Referer")
-		isSameOrigin := strings.HasPrefix(referer, "http://127.0.0.1:") ||
-			strings.HasPrefix(referer, "http://localhost:")
-
-		if token != cfg.BearerToken && !isSameOrigin {
-			http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
-			return
-		}
-		next(w, r)
-	}
-}
-
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
@@ -151,46 +280,144 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-func handleConfig(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet:
-		writeJSON(w, http.StatusOK, config.Get())
+// handleConfig serves the config API. Agent entries are validated against the
+// registered manifests before saving and applied to the running fleet after;
+// schedules are validated and applied to the scheduler the same way.
+func handleConfig(mgr *agent.Manager, sched *scheduler.Scheduler, auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			// Only tokens that may change the config see its secrets.
+			c := config.Get()
+			if !identityFrom(r.Context()).can(config.ScopeConfigWrite) {
+				c = redactConfig(c)
+			}
+			writeJSON(w, http.StatusOK, c)
 
-	case http.MethodPut:
-		var c config.Config
-		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
-			return
-		}
-		updated, err := config.Replace(c)
-		if err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
-			return
-		}
-		writeJSON(w, http.StatusOK, updated)
+		case http.MethodPut:
+			var c config.Config
+			if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if mgr != nil {
+				if err := mgr.ValidateConfig(c); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+					return
+				}
+				if err := validateSchedules(mgr, c.Schedules); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+					return
+				}
+			}
+			before := config.Get()
+			updated, err := config.Replace(c)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			appendAudit(auditLog, r, audit.Record{Action: "config.replace", Target: "config", Diff: configDiff(before, updated)})
+			applyAgentConfig(mgr, updated)
+			applySchedules(sched, updated)
+			writeJSON(w, http.StatusOK, updated)
 
-	case http.MethodPatch:
-		var partial map[string]json.RawMessage
-		if err := json.NewDecoder(r.Body).Decode(&partial); err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
-			return
-		}
-		updated, err := config.Update(func(c *config.Config) {
-			if v, ok := partial["port"]; ok {
-				json.Unmarshal(v, &c.Port)
+		case http.MethodPatch:
+			var partial map[string]json.RawMessage
+			if err := json.NewDecoder(r.Body).Decode(&partial); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
 			}
-			if v, ok := partial["auto_open_browser"]; ok {
-				json.Unmarshal(v, &c.AutoOpen)
+			// Decode fleet settings up front so they can be validated
+			// against the manifests before anything is saved.
+			probe := config.Get()
+			if v, ok := partial["agents"]; ok {
+				probe.Agents = nil
+				if err := json.Unmarshal(v, &probe.Agents); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "agents: " + err.Error()})
+					return
+				}
 			}
-		})
-		if err != nil {
-			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
-			return
+			if v, ok := partial["skills"]; ok {
+				probe.Skills = nil
+				if err := json.Unmarshal(v, &probe.Skills); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "skills: " + err.Error()})
+					return
+				}
+			}
+			if v, ok := partial["schedules"]; ok {
+				probe.Schedules = nil
+				if err := json.Unmarshal(v, &probe.Schedules); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "schedules: " + err.Error()})
+					return
+				}
+			}
+			if mgr != nil {
+				if err := mgr.ValidateConfig(probe); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+					return
+				}
+				if err := validateSchedules(mgr, probe.Schedules); err != nil {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+					return
+				}
+			}
+			before := config.Get()
+			updated, err := config.Update(func(c *config.Config) {
+				if v, ok := partial["port"]; ok {
+					json.Unmarshal(v, &c.Port)
+				}
+				if v, ok := partial["auto_open_browser"]; ok {
+					json.Unmarshal(v, &c.AutoOpen)
+				}
+				if _, ok := partial["agents"]; ok {
+					c.Agents = probe.Agents
+				}
+				if _, ok := partial["skills"]; ok {
+					c.Skills = probe.Skills
+				}
+				if _, ok := partial["schedules"]; ok {
+					c.Schedules = probe.Schedules
+				}
+			})
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			appendAudit(auditLog, r, audit.Record{Action: "config.patch", Target: "config", Diff: configDiff(before, updated)})
+			applyAgentConfig(mgr, updated)
+			applySchedules(sched, updated)
+			writeJSON(w, http.StatusOK, updated)
+
+		default:
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
 		}
-		writeJSON(w, http.StatusOK, updated)
+	}
+}
 
-	default:
-		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+// redactConfig strips the secrets from a config: the admin and API tokens
+// are dropped and webhook secrets reduced to a fingerprint. Every secret a
+// config can hold must be handled here; it guards both GET /api/v1/config
+// and the audit log's diffs.
+func redactConfig(c config.Config) config.Config {
+	c.BearerToken = ""
+	c.RetiredToken = nil
+	c.Tokens = nil
+	c.Webhooks = slices.Clone(c.Webhooks)
+	for i, wh := range c.Webhooks {
+		if wh.Secret != "" {
+			sum := sha256.Sum256([]byte(wh.Secret))
+			c.Webhooks[i].Secret = "sha256:" + hex.EncodeToString(sum[:4])
+		}
+	}
+	return c
+}
+
+func applyAgentConfig(mgr *agent.Manager, c config.Config) {
+	if mgr == nil {
+		return
+	}
+	if err := mgr.ApplyConfig(c); err != nil {
+		slog.Error("failed to apply agent config", "error", err)
 	}
 }
 
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"idra/internal/config"
+)
+
+const (
+	sessionCookie = "idra_session"
+	sessionTTL    = 24 * time.Hour
+	loginCodeTTL  = 15 * time.Minute
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// session is a signed-in web UI. Requests carry its ID in an HttpOnly
+// cookie; state-changing ones must also send its CSRF token in a header,
+// which only same-origin pages can read.
+type session struct {
+	id        identity
+	token     string // name of the API token it was opened with; empty for admin sessions
+	tokenHash string // hash of the token it was opened with, or of the bearer token for a login link
+	csrf      string
+	expires   time.Time
+}
+
+func (s *session) checkCSRF(r *http.Request) bool {
+	got := r.Header.Get(csrfHeader)
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(s.csrf)) == 1
+}
+
+// sessionStore keeps web UI sessions and one-time login codes in memory;
+// after a restart the UI signs in again.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*session  // keyed by cookie value
+	codes    map[string]time.Time // login code → expiry
+}
+
+var sessions = &sessionStore{
+	sessions: make(map[string]*session),
+	codes:    make(map[string]time.Time),
+}
+
+// loginCode returns a new code that opens an admin session once.
+func (st *sessionStore) loginCode() string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+	for code, exp := range st.codes {
+		if now.After(exp) {
+			delete(st.codes, code)
+		}
+	}
+	code := randomHex(32)
+	st.codes[code] = now.Add(loginCodeTTL)
+	return code
+}
+
+// redeem reports whether code is a valid login code and invalidates it.
+func (st *sessionStore) redeem(code string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	exp, ok := st.codes[code]
+	delete(st.codes, code)
+	return ok && time.Now().Before(exp)
+}
+
+// open starts a session for id and returns its cookie value. token names
+// the API token the session was opened with, empty for the admin token, and
+// hash is that token's hash.
+func (st *sessionStore) open(id identity, token, hash string) (string, *session) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	now := time.Now()
+	for v, s := range st.sessions {
+		if now.After(s.expires) {
+			delete(st.sessions, v)
+		}
+	}
+	s := &session{id: id, token: token, tokenHash: hash, csrf: randomHex(32), expires: now.Add(sessionTTL)}
+	value := randomHex(32)
+	st.sessions[value] = s
+	return value, s
+}
+
+// get returns the live session for a cookie value, or nil.
+func (st *sessionStore) get(value string) *session {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s := st.sessions[value]
+	if s == nil || time.Now().After(s.expires) {
+		delete(st.sessions, value)
+		return nil
+	}
+	return s
+}
+
+func (st *sessionStore) close(value string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.sessions, value)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LoginURL returns a one-time link that signs the web UI in as admin. It
+// expires after 15 minutes.
+func (s *Server) LoginURL() string {
+	return "http://" + s.addr + "/login?code=" + sessions.loginCode()
+}
+
+func setSessionCookie(w http.ResponseWriter, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   int(sessionTTL / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// handleLogin serves GET /login?code=...: a valid one-time code opens an
+// admin session. Either way the browser is sent to the UI, which asks for a
+// token if there is no session.
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if sessions.redeem(r.URL.Query().Get("code")) {
+		hash := config.HashToken(config.Get().BearerToken)
+		value, _ := sessions.open(identity{Name: config.WebUIName, Scopes: config.Scopes}, "", hash)
+		setSessionCookie(w, value)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// sessionInfo describes the current web UI session.
+type sessionInfo struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Skills    []string `json:"skills,omitempty"`
+	CSRFToken string   `json:"csrf_token"`
+}
+
+// handleSession serves /api/v1/session: GET returns the session and its
+// CSRF token, POST {"token": ...} signs in with the bearer token or an API
+// token, DELETE signs out. Sign-ins must come from the web UI at addr, so
+// another site can't sign the browser in to a session of its choosing.
+func handleSession(addr string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			id, s, err := authenticate(r)
+			if err != nil || s == nil {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "no session"})
+				return
+			}
+			writeJSON(w, http.StatusOK, sessionInfo{Name: id.Name, Scopes: id.Scopes, Skills: id.Skills, CSRFToken: s.csrf})
+
+		case http.MethodPost:
+			if !sameOrigin(r, addr) {
+				writeJSON(w, http.StatusForbidden, map[string]string{"error": "sign-in must come from the web UI"})
+				return
+			}
+			var body struct {
+				Token string `json:"token"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			id, err := resolveToken(body.Token)
+			if err != nil {
+				writeJSON(w, http.StatusUnauthorized, map[string]string{"error": err.Error()})
+				return
+			}
+			hash, token := config.HashToken(body.Token), ""
+			for _, t := range config.Get().Tokens {
+				if t.Name == id.Name && t.Hash == hash {
+					token = t.Name
+					break
+				}
+			}
+			value, s := sessions.open(id, token, hash)
+			setSessionCookie(w, value)
+			writeJSON(w, http.StatusOK, sessionInfo{Name: id.Name, Scopes: id.Scopes, Skills: id.Skills, CSRFToken: s.csrf})
+
+		case http.MethodDelete:
+			c, err := r.Cookie(sessionCookie)
+			if err != nil {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if s := sessions.get(c.Value); s != nil {
+				if !s.checkCSRF(r) {
+					writeJSON(w, http.StatusForbidden, map[string]string{"error": "missing or invalid CSRF token"})
+					return
+				}
+				sessions.close(c.Value)
+			}
+			http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// sameOrigin reports whether r was sent by a page served from addr, reached
+// as its IP address or as localhost.
+func sameOrigin(r *http.Request, addr string) bool {
+	_, port, _ := net.SplitHostPort(addr)
+	origin := r.Header.Get("Origin")
+	return origin == "http://"+addr || origin == "http://localhost:"+port
+}
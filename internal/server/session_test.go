@@ -0,0 +1,33 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSameOrigin(t *testing.T) {
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"http://127.0.0.1:8080", true},
+		{"http://localhost:8080", true},
+		{"", false},
+		{"null", false},
+		{"http://127.0.0.1:8081", false},
+		{"https://127.0.0.1:8080", false},
+		{"http://evil.example", false},
+		{"http://localhost:8080.evil.example", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.origin, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/v1/session", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := sameOrigin(r, "127.0.0.1:8080"); got != tt.want {
+				t.Errorf("sameOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
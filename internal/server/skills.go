@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"idra/internal/agent"
+	"idra/internal/agent/pb"
+	"idra/internal/audit"
+)
+
+// handleSkills serves GET /api/v1/skills: every registered skill, its
+// routing strategy and the agents that provide it.
+func handleSkills(mgr *agent.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, http.StatusOK, mgr.Skills())
+	}
+}
+
+// handleSkillTasks serves POST /api/v1/skills/{skill}/tasks, routing the task
+// to a provider of the skill chosen by its routing strategy, with failover.
+// With broadcast=true the task goes to every running provider instead.
+func handleSkillTasks(env *taskEnv) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+
+		skill := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/skills/"), "/tasks")
+		if skill == "" || strings.Contains(skill, "/") {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing skill name"})
+			return
+		}
+
+		var body taskBody
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if body.Skill != "" && body.Skill != skill {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "skill in body does not match path"})
+			return
+		}
+		body.Skill = skill
+		if body.Timeout < 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "timeout must not be negative"})
+			return
+		}
+
+		if r.URL.Query().Get("broadcast") == "true" {
+			broadcastTask(w, r, env, body)
+			return
+		}
+		submitTask(w, r, env, "", body)
+	}
+}
+
+// broadcastTask runs the task on every running provider of its skill and
+// responds with each agent's answer and the aggregated result. Every agent's
+// copy is a regular task, so it appears in the task history and can be
+// cancelled on its own. Broadcasts are synchronous and can't have a
+// callback.
+func broadcastTask(w http.ResponseWriter, r *http.Request, env *taskEnv, body taskBody) {
+	if !allowSkill(w, r, body.Skill) {
+		return
+	}
+	agg, err := agent.ParseAggregator(body.Aggregate)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if r.URL.Query().Get("async") == "true" || wantsEventStream(r) || body.CallbackURL != "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "broadcast tasks are synchronous and don't support callback_url"})
+		return
+	}
+	req := body.request()
+	if _, err := agent.ParsePriority(req.Metadata["priority"]); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	appendAudit(env.audit, r, audit.Record{Action: "task.broadcast", Target: req.TaskId, Summary: fmt.Sprintf("skill %q, aggregate %s", req.Skill, agg)})
+	exec := taskExecutor(env)
+	timeout := body.Timeout.Std()
+	b, err := env.mgr.BroadcastSkill(r.Context(), req, agg, func(ctx context.Context, agentName string, sub *pb.TaskRequest) ([]*pb.TaskEvent, error) {
+		_, events, err := exec(ctx, sub, agentName, timeout)
+		return events, err
+	})
+	if err != nil {
+		writeJSON(w, taskErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"task_id":   req.TaskId,
+		"broadcast": b,
+	})
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"idra/internal/agent/pb"
+)
+
+// wantsEventStream reports whether the client asked for Server-Sent Events.
+func wantsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+// sseWriter writes Server-Sent Events and flushes after each one.
+type sseWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// newSSEWriter sends the event-stream headers. It fails if the response
+// writer cannot flush.
+func newSSEWriter(w http.ResponseWriter) (*sseWriter, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, fmt.Errorf("streaming not supported")
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &sseWriter{w: w, flusher: flusher}, nil
+}
+
+// Send writes one event with v encoded as JSON in the data field.
+func (s *sseWriter) Send(event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// streamTask relays the events of a task as SSE. Each pb.TaskEvent is sent
+// with its type as the event name, and the stream ends with a "done" event
+// carrying the task ID and the agent that ran it. The stream is opened on
+// the first event: a failure before that (unknown agent, agent not running)
+// is reported as a regular JSON error response, a failure after it as a
+// final "error" event.
+//
+// run executes the task, calling emit for every event, and returns the name
+// of the agent that handled it.
+func streamTask(w http.ResponseWriter, req *pb.TaskRequest, run func(emit func(*pb.TaskEvent) error) (string, error)) {
+	var sse *sseWriter
+	agentName, err := run(func(ev *pb.TaskEvent) error {
+		if sse == nil {
+			var err error
+			if sse, err = newSSEWriter(w); err != nil {
+				return err
+			}
+		}
+		return sse.Send(ev.Type, ev)
+	})
+
+	if sse == nil {
+		if err != nil {
+			writeJSON(w, taskErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		// The agent finished without emitting anything.
+		if sse, err = newSSEWriter(w); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+	} else if err != nil {
+		sse.Send("error", &pb.TaskEvent{TaskId: req.TaskId, Type: "error", Payload: err.Error()})
+	}
+
+	done := map[string]string{"task_id": req.TaskId}
+	if agentName != "" {
+		done["agent"] = agentName
+	}
+	sse.Send("done", done)
+}
@@ -0,0 +1,437 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"idra/internal/agent"
+	"idra/internal/agent/pb"
+	"idra/internal/audit"
+	"idra/internal/config"
+	"idra/internal/task"
+	"idra/internal/webhook"
+	"idra/internal/workflow"
+)
+
+// taskEnv is what submitting and executing tasks needs: the fleet, the task
+// history, the dead-letter queue, the webhook sender for callbacks and the
+// audit log.
+type taskEnv struct {
+	mgr      *agent.Manager
+	tasks    *task.Tracker
+	dlq      *task.DeadLetters
+	webhooks *webhook.Sender
+	audit    *audit.Log
+}
+
+// runFunc executes a task, calling emit for every event, and returns the
+// name of the agent that handled it.
+type runFunc func(ctx context.Context, emit func(*pb.TaskEvent) error) (string, error)
+
+// routeTask returns the runFunc for req: on agentName, or on a provider of
+// the skill if agentName is empty. timeout is the requested task timeout.
+func routeTask(mgr *agent.Manager, req *pb.TaskRequest, agentName string, timeout time.Duration) runFunc {
+	return func(ctx context.Context, emit func(*pb.TaskEvent) error) (string, error) {
+		ctx = agent.WithTaskTimeout(ctx, timeout)
+		if agentName == "" {
+			return mgr.RouteSkillStream(ctx, req, emit)
+		}
+		return agentName, mgr.RouteTaskStream(ctx, agentName, req, emit)
+	}
+}
+
+// submitTask checks that the task in body can be routed, records it in the
+// tracker and executes it in one of three modes: in the background when the query has
+// async=true (202 Accepted), as Server-Sent Events when the client accepts
+// text/event-stream, or synchronously with all events in the JSON response.
+// agentName is empty for skill-routed tasks until the task is placed. Tasks
+// that would not fit in the agent's queue get 429. A request with an
+// Idempotency-Key header that was seen before returns the original task
+// instead of running again. For cached skills, synchronous and streamed
+// responses carry an X-Idra-Cache header, and Cache-Control: no-cache skips
+// the cache lookup. With a callback_url the finished task is also
+// POSTed to that URL, which must match a configured webhook.
+func submitTask(w http.ResponseWriter, r *http.Request, env *taskEnv, agentName string, body taskBody) {
+	mgr, tasks := env.mgr, env.tasks
+	req := body.request()
+	if !allowSkill(w, r, req.Skill) {
+		return
+	}
+	if err := mgr.CheckRoute(agentName, req.Skill); err != nil {
+		writeJSON(w, taskErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+	if _, err := agent.ParsePriority(req.Metadata["priority"]); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if body.CallbackURL != "" {
+		if err := checkCallbackURL(body.CallbackURL); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) > 255 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Idempotency-Key is longer than 255 characters"})
+		return
+	}
+	if prev, ok := tasks.Lookup(key); key != "" && ok {
+		replayTask(w, prev, req, agentName)
+		return
+	}
+	if err := mgr.CheckCapacity(agentName, req.Skill); err != nil {
+		writeJSON(w, taskErrorStatus(err), map[string]string{"error": err.Error()})
+		return
+	}
+
+	// Async tasks are detached from the request so they outlive the
+	// connection. Either way the tracker can abort them on cancellation.
+	async := r.URL.Query().Get("async") == "true"
+	base := r.Context()
+	if async {
+		base = context.Background()
+	}
+	ctx, cancel := context.WithCancel(base)
+	if strings.Contains(r.Header.Get("Cache-Control"), "no-cache") {
+		ctx = agent.WithoutCache(ctx)
+	}
+	if !async {
+		ctx = agent.WithCacheNotify(ctx, func(s agent.CacheStatus) { w.Header().Set("X-Idra-Cache", string(s)) })
+	}
+	if prev, created := tasks.Create(req, agentName, key, cancel); !created {
+		cancel()
+		replayTask(w, prev, req, agentName)
+		return
+	}
+	appendAudit(env.audit, r, audit.Record{Action: "task.submit", Target: req.TaskId, Summary: taskSummary(req.Skill, agentName)})
+	if body.CallbackURL != "" {
+		tasks.SetCallback(req.TaskId, body.CallbackURL)
+	}
+
+	timeout := body.Timeout.Std()
+	run := routeTask(mgr, req, agentName, timeout)
+	tracked := func(emit func(*pb.TaskEvent) error) (string, error) {
+		defer cancel()
+		return trackTask(ctx, env, req, agentName, timeout, run, emit)
+	}
+
+	switch {
+	case async:
+		go tracked(func(*pb.TaskEvent) error { return nil })
+		w.Header().Set("Location", "/api/v1/tasks/"+req.TaskId)
+		writeJSON(w, http.StatusAccepted, map[string]any{
+			"task_id": req.TaskId,
+			"state":   task.StateQueued,
+		})
+
+	case wantsEventStream(r):
+		streamTask(w, req, tracked)
+
+	default:
+		var events []*pb.TaskEvent
+		name, err := tracked(func(ev *pb.TaskEvent) error {
+			events = append(events, ev)
+			return nil
+		})
+		if err != nil {
+			writeJSON(w, taskErrorStatus(err), map[string]string{"error": err.Error()})
+			return
+		}
+		resp := map[string]any{
+			"task_id": req.TaskId,
+			"events":  events,
+		}
+		if agentName == "" {
+			resp["agent"] = name
+		}
+		status := http.StatusOK
+		if n := len(events); n > 0 && events[n-1].Type == "timeout" {
+			status = http.StatusGatewayTimeout
+		}
+		writeJSON(w, status, resp)
+	}
+}
+
+// trackTask executes a task created in the tracker so that every event,
+// every attempt and the outcome land in the tracker, and returns the name
+// of the agent that handled it. A task that fails or times out is added to
+// the dead-letter queue along with what it takes to run it again, and a task
+// with a callback is delivered to its webhook in the background.
+func trackTask(ctx context.Context, env *taskEnv, req *pb.TaskRequest, agentName string, timeout time.Duration, run runFunc, emit func(*pb.TaskEvent) error) (string, error) {
+	tasks := env.tasks
+	ctx = agent.WithStartNotify(ctx, func(name string) { tasks.Start(req.TaskId, name) })
+	ctx = agent.WithCacheNotify(ctx, func(s agent.CacheStatus) {
+		if s == agent.CacheHit {
+			tasks.SetCached(req.TaskId)
+		}
+	})
+	ctx = agent.WithAttemptNotify(ctx, func(a agent.Attempt) {
+		rec := task.Attempt{Agent: a.Agent, StartedAt: a.Started.UTC(), FinishedAt: a.Finished.UTC()}
+		if a.Err != nil {
+			rec.Error = a.Err.Error()
+			rec.Class = string(agent.Classify(a.Err))
+		}
+		tasks.AddAttempt(req.TaskId, rec)
+	})
+
+	var last *pb.TaskEvent
+	name, err := run(ctx, func(ev *pb.TaskEvent) error {
+		last = ev
+		tasks.AddEvent(req.TaskId, ev)
+		return emit(ev)
+	})
+	if name != "" {
+		tasks.SetAgent(req.TaskId, name)
+	}
+	// A task whose deadline passed ends with a "timeout" event.
+	if errors.Is(err, agent.ErrTimeout) {
+		ev := &pb.TaskEvent{TaskId: req.TaskId, Type: "timeout", Payload: err.Error()}
+		tasks.AddEvent(req.TaskId, ev)
+		emit(ev)
+		err = nil
+	}
+	// A task stopped by DELETE, or whose client went away, ends with a
+	// "cancelled" event even if the agent didn't send one.
+	if err != nil && ctx.Err() != nil && (last == nil || last.Type != "cancelled") {
+		reason := "client disconnected"
+		if tasks.CancelRequested(req.TaskId) {
+			reason = "cancelled by request"
+		}
+		ev := &pb.TaskEvent{TaskId: req.TaskId, Type: "cancelled", Payload: reason}
+		tasks.AddEvent(req.TaskId, ev)
+		emit(ev)
+		err = nil
+	}
+
+	final := tasks.Finish(req.TaskId, err)
+	if final.State == task.StateFailed || final.State == task.StateTimedOut {
+		d := task.DeadLetter{
+			TaskID:   req.TaskId,
+			Request:  req,
+			Agent:    agentName,
+			Timeout:  agent.Duration(timeout),
+			State:    final.State,
+			Error:    final.Error,
+			Attempts: final.Attempts,
+			FailedAt: time.Now().UTC(),
+		}
+		if final.Callback != nil {
+			d.Callback = final.Callback.URL
+		}
+		if err := env.dlq.Add(d); err != nil {
+			slog.Error("dead-letter task", "task_id", req.TaskId, "error", err)
+		}
+	}
+	if final.Callback != nil {
+		go deliverCallback(env, final)
+	}
+	return name, err
+}
+
+// checkCallbackURL reports whether callbacks may be sent to u.
+func checkCallbackURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("callback_url must be an absolute http or https URL")
+	}
+	if _, ok := config.Get().WebhookFor(u); !ok {
+		return fmt.Errorf("callback_url does not match a configured webhook")
+	}
+	return nil
+}
+
+// deliverCallback POSTs the finished task to its callback URL, signed with
+// the secret of the matching webhook, and records every attempt on the task.
+func deliverCallback(env *taskEnv, t task.Task) {
+	cb := t.Callback
+	wh, ok := config.Get().WebhookFor(cb.URL)
+	if !ok {
+		slog.Warn("webhook removed, not delivering task callback", "task_id", t.ID, "url", cb.URL)
+		return
+	}
+	t.Callback = nil
+	body, err := json.Marshal(t)
+	if err != nil {
+		slog.Error("marshal task callback", "task_id", t.ID, "error", err)
+		return
+	}
+	err = env.webhooks.Send(context.Background(), cb.URL, wh.Secret, "task.finished", body, func(a webhook.Attempt) {
+		env.tasks.AddDelivery(t.ID, a)
+	})
+	if err != nil {
+		slog.Warn("task callback not delivered", "task_id", t.ID, "url", cb.URL, "webhook", wh.Name, "error", err)
+	}
+}
+
+// taskExecutor runs workflow steps and scheduled tasks as regular tasks, so
+// they show up in the task history, can be cancelled and are dead-lettered
+// when they fail.
+func taskExecutor(env *taskEnv) workflow.Executor {
+	return func(ctx context.Context, req *pb.TaskRequest, agentName string, timeout time.Duration) (string, []*pb.TaskEvent, error) {
+		if err := env.mgr.CheckRoute(agentName, req.Skill); err != nil {
+			return "", nil, err
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		env.tasks.Create(req, agentName, "", cancel)
+
+		var events []*pb.TaskEvent
+		name, err := trackTask(ctx, env, req, agentName, timeout, routeTask(env.mgr, req, agentName, timeout),
+			func(ev *pb.TaskEvent) error {
+				events = append(events, ev)
+				return nil
+			})
+		return name, events, err
+	}
+}
+
+// handleTasks serves GET /api/v1/tasks: task history, newest first, without
+// their events. Filters: agent, skill, state, since and until (RFC 3339
+// timestamps, or a duration like "24h" meaning "that long ago") and limit
+// (default 100).
+func handleTasks(tasks *task.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+
+		f, err := parseFilter(r.URL.Query())
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		f.Skills = identityFrom(r.Context()).Skills
+		writeJSON(w, http.StatusOK, tasks.List(f))
+	}
+}
+
+// parseFilter reads the agent, skill, state, since, until and limit query
+// parameters shared by the task and dead-letter listings. limit defaults to
+// 100.
+func parseFilter(q url.Values) (task.Filter, error) {
+	state, err := task.ParseState(q.Get("state"))
+	if err != nil {
+		return task.Filter{}, err
+	}
+	since, err := parseTime(q.Get("since"))
+	if err != nil {
+		return task.Filter{}, fmt.Errorf("since %w", err)
+	}
+	until, err := parseTime(q.Get("until"))
+	if err != nil {
+		return task.Filter{}, fmt.Errorf("until %w", err)
+	}
+	limit := 100
+	if v := q.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return task.Filter{}, fmt.Errorf("limit must be a positive integer")
+		}
+	}
+	return task.Filter{
+		Agent: q.Get("agent"),
+		Skill: q.Get("skill"),
+		State: state,
+		Since: since,
+		Until: until,
+		Limit: limit,
+	}, nil
+}
+
+// replayTask answers a submission whose Idempotency-Key matches an earlier
+// task with that task's current record instead of executing it again. The
+// key must not be reused for a different request.
+func replayTask(w http.ResponseWriter, prev task.Task, req *pb.TaskRequest, agentName string) {
+	if prev.Skill != req.Skill || prev.Input != req.Input || !maps.Equal(prev.Metadata, req.Metadata) ||
+		(agentName != "" && prev.Agent != agentName) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "Idempotency-Key was already used for a different request"})
+		return
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.Header().Set("Location", "/api/v1/tasks/"+prev.ID)
+	writeJSON(w, http.StatusOK, prev)
+}
+
+// cancelGrace is how long an agent that accepted a Cancel RPC gets to end
+// the task's stream before it is dropped.
+const cancelGrace = 5 * time.Second
+
+// handleTask serves /api/v1/tasks/{id}. GET returns the task's state,
+// timestamps and the events collected so far. DELETE cancels a running task:
+// the agent is asked to stop through the Cancel RPC and, if it doesn't
+// implement it or doesn't stop within cancelGrace, the task's stream is
+// dropped. The response is the task after it has finished.
+func handleTask(mgr *agent.Manager, tasks *task.Tracker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/v1/tasks/")
+		t, ok := tasks.Get(id)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "task not found"})
+			return
+		}
+		if !allowSkill(w, r, t.Skill) {
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, t)
+
+		case http.MethodDelete:
+			if t.State.Terminal() {
+				writeJSON(w, http.StatusConflict, map[string]string{"error": "task already " + string(t.State)})
+				return
+			}
+			done, abort, ok := tasks.RequestCancel(id)
+			if !ok {
+				writeJSON(w, http.StatusConflict, map[string]string{"error": "task is not running"})
+				return
+			}
+
+			grace := time.Duration(0)
+			agentName, err := mgr.CancelTask(r.Context(), id)
+			if err == nil {
+				grace = cancelGrace
+			} else {
+				slog.Debug("cancel RPC failed, dropping task stream", "task_id", id, "agent", agentName, "error", err)
+			}
+			select {
+			case <-done:
+			case <-time.After(grace):
+				abort()
+				<-done
+			}
+
+			t, _ = tasks.Get(id)
+			writeJSON(w, http.StatusOK, t)
+
+		default:
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// parseTime accepts an RFC 3339 timestamp or a duration before now. The
+// empty string yields the zero time.
+func parseTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("must be an RFC 3339 timestamp or a duration")
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"idra/internal/audit"
+	"idra/internal/config"
+)
+
+// tokenInfo is an API token as reported by the API, without its hash.
+type tokenInfo struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Skills    []string   `json:"skills,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Expired   bool       `json:"expired,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func newTokenInfo(t config.TokenConfig) tokenInfo {
+	return tokenInfo{
+		Name:      t.Name,
+		Scopes:    t.Scopes,
+		Skills:    t.Skills,
+		ExpiresAt: t.ExpiresAt,
+		Expired:   t.Expired(),
+		CreatedAt: t.CreatedAt,
+	}
+}
+
+// tokenBody is the JSON body of a token creation request.
+type tokenBody struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	Skills    []string `json:"skills,omitempty"`
+	ExpiresIn string   `json:"expires_in,omitempty"` // e.g. "720h"; empty = never
+}
+
+// handleTokens serves /api/v1/tokens: GET lists the API tokens, POST
+// creates one. The token itself is only in the creation response; config.json
+// keeps its SHA-256 hash.
+func handleTokens(auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			out := make([]tokenInfo, 0)
+			for _, t := range config.Get().Tokens {
+				out = append(out, newTokenInfo(t))
+			}
+			writeJSON(w, http.StatusOK, out)
+
+		case http.MethodPost:
+			var body tokenBody
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			if body.Name == "" || strings.Contains(body.Name, "/") {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name is required and must not contain '/'"})
+				return
+			}
+			if body.Name == config.AdminName || body.Name == config.WebUIName {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "name " + body.Name + " is reserved"})
+				return
+			}
+			token, hash := config.NewToken()
+			tc := config.TokenConfig{
+				Name:      body.Name,
+				Hash:      hash,
+				Scopes:    body.Scopes,
+				Skills:    body.Skills,
+				CreatedAt: time.Now().UTC(),
+			}
+			if body.ExpiresIn != "" {
+				d, err := time.ParseDuration(body.ExpiresIn)
+				if err != nil || d <= 0 {
+					writeJSON(w, http.StatusBadRequest, map[string]string{"error": "expires_in must be a positive duration like \"720h\""})
+					return
+				}
+				exp := tc.CreatedAt.Add(d)
+				tc.ExpiresAt = &exp
+			}
+
+			exists := false
+			_, err := config.Update(func(c *config.Config) {
+				if slices.ContainsFunc(c.Tokens, func(t config.TokenConfig) bool { return t.Name == tc.Name }) {
+					exists = true
+					return
+				}
+				c.Tokens = append(slices.Clone(c.Tokens), tc)
+			})
+			if exists {
+				writeJSON(w, http.StatusConflict, map[string]string{"error": "token already exists"})
+				return
+			}
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			appendAudit(auditLog, r, audit.Record{Action: "token.create", Target: tc.Name, Summary: "scopes " + strings.Join(tc.Scopes, ",")})
+			w.Header().Set("Location", "/api/v1/tokens/"+tc.Name)
+			writeJSON(w, http.StatusCreated, map[string]any{
+				"token":      token,
+				"token_info": newTokenInfo(tc),
+			})
+
+		default:
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleToken serves /api/v1/tokens/{name}: GET returns the token's scopes
+// and expiry, DELETE revokes it.
+func handleToken(auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/api/v1/tokens/")
+		tokens := config.Get().Tokens
+		i := slices.IndexFunc(tokens, func(t config.TokenConfig) bool { return t.Name == name })
+		if i < 0 {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "token not found"})
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, newTokenInfo(tokens[i]))
+
+		case http.MethodDelete:
+			_, err := config.Update(func(c *config.Config) {
+				c.Tokens = slices.DeleteFunc(slices.Clone(c.Tokens), func(t config.TokenConfig) bool { return t.Name == name })
+			})
+			if err != nil {
+				writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+				return
+			}
+			appendAudit(auditLog, r, audit.Record{Action: "token.revoke", Target: name})
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+// handleRotateBearer serves POST /api/v1/bearer-token/rotate: it replaces
+// the admin bearer token and returns the new one. {"grace": "1h"} keeps the
+// old token valid for that long so clients can switch over.
+func handleRotateBearer(auditLog *audit.Log) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+		var body struct {
+			Grace string `json:"grace"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+		}
+		var grace time.Duration
+		if body.Grace != "" {
+			d, err := time.ParseDuration(body.Grace)
+			if err != nil || d < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "grace must be a duration like \"1h\""})
+				return
+			}
+			grace = d
+		}
+
+		token, cfg, err := config.RotateBearerToken(grace)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		resp := map[string]any{"token": token}
+		if cfg.RetiredToken != nil {
+			resp["previous_valid_until"] = cfg.RetiredToken.ExpiresAt
+		}
+		slog.Info("bearer token rotated", "by", identityFrom(r.Context()).Name, "remote", r.RemoteAddr, "grace", grace)
+		appendAudit(auditLog, r, audit.Record{Action: "token.rotate", Target: config.AdminName, Summary: "grace " + grace.String()})
+		writeJSON(w, http.StatusOK, resp)
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"idra/internal/agent"
+	"idra/internal/audit"
+	"idra/internal/workflow"
+)
+
+// handleWorkflows serves GET /api/v1/workflows: every valid workflow
+// definition in the workflows directory.
+func handleWorkflows(dir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+		wfs, err := workflow.LoadAll(dir)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, wfs)
+	}
+}
+
+// handleWorkflow serves /api/v1/workflows/{name}[/runs[/{id}]]: GET on the
+// name returns the definition, POST on runs starts a run and GET on runs
+// lists them, and GET on a run returns its state with per-step status.
+//
+// A run is synchronous unless the query has async=true (202 Accepted);
+// either way the response is the run record. Definitions are read from disk
+// on every request, so edits take effect without a restart.
+func handleWorkflow(mgr *agent.Manager, engine *workflow.Engine, auditLog *audit.Log, dir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/api/v1/workflows/")
+		name, rest, _ := strings.Cut(path, "/")
+		wf, err := workflow.Load(dir, name)
+		if err != nil {
+			status := http.StatusUnprocessableEntity
+			if errors.Is(err, workflow.ErrUnknownWorkflow) {
+				status = http.StatusNotFound
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+
+		switch {
+		case rest == "" && r.Method == http.MethodGet:
+			writeJSON(w, http.StatusOK, wf)
+
+		case rest == "runs" && r.Method == http.MethodGet:
+			id := identityFrom(r.Context())
+			runs := slices.DeleteFunc(engine.List(wf.Name), func(run workflow.Run) bool { return !id.canRunAll(runSkills(run)) })
+			writeJSON(w, http.StatusOK, runs)
+
+		case rest == "runs" && r.Method == http.MethodPost:
+			startRun(w, r, mgr, engine, auditLog, wf)
+
+		case strings.HasPrefix(rest, "runs/") && r.Method == http.MethodGet:
+			run, ok := engine.Get(strings.TrimPrefix(rest, "runs/"))
+			if !ok || run.Workflow != wf.Name {
+				writeJSON(w, http.StatusNotFound, map[string]string{"error": "run not found"})
+				return
+			}
+			if !allowSkills(w, r, runSkills(run)) {
+				return
+			}
+			writeJSON(w, http.StatusOK, run)
+
+		case rest == "" || rest == "runs" || strings.HasPrefix(rest, "runs/"):
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}
+}
+
+// runSkills returns the skills of a run's steps.
+func runSkills(run workflow.Run) []string {
+	skills := make([]string, len(run.Steps))
+	for i, s := range run.Steps {
+		skills[i] = s.Skill
+	}
+	return skills
+}
+
+// runBody is the JSON body of a workflow run request.
+type runBody struct {
+	Input    string            `json:"input"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// startRun checks that every step can be routed and starts a run of wf.
+func startRun(w http.ResponseWriter, r *http.Request, mgr *agent.Manager, engine *workflow.Engine, auditLog *audit.Log, wf *workflow.Workflow) {
+	var body runBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if _, err := agent.ParsePriority(body.Metadata["priority"]); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	for _, s := range wf.Steps {
+		if !allowSkill(w, r, s.Skill) {
+			return
+		}
+		if err := mgr.CheckRoute(s.Agent, s.Skill); err != nil {
+			writeJSON(w, taskErrorStatus(err), map[string]string{"error": "step " + s.ID + ": " + err.Error()})
+			return
+		}
+	}
+
+	// Async runs are detached from the request so they outlive the
+	// connection; a synchronous run is cancelled if the client goes away.
+	async := r.URL.Query().Get("async") == "true"
+	ctx := r.Context()
+	if async {
+		ctx = context.Background()
+	}
+	run, done := engine.Start(ctx, wf, body.Input, body.Metadata)
+	appendAudit(auditLog, r, audit.Record{Action: "workflow.run", Target: run.ID, Summary: fmt.Sprintf("workflow %q, %d steps", wf.Name, len(wf.Steps))})
+
+	w.Header().Set("Location", "/api/v1/workflows/"+wf.Name+"/runs/"+run.ID)
+	if async {
+		writeJSON(w, http.StatusAccepted, run)
+		return
+	}
+	<-done
+	run, _ = engine.Get(run.ID)
+	writeJSON(w, http.StatusOK, run)
+}
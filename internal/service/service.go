@@ -38,6 +38,9 @@ func (p *program) Start(s service.Service) error {
 			slog.Warn("agent registry error", "error", err)
 		} else {
 			p.mgr = agent.NewManager(reg)
+			if err := p.mgr.ApplyConfig(cfg); err != nil {
+				slog.Error("invalid agent config, using manifests as-is", "error", err)
+			}
 			p.mgr.StartAll(p.ctx)
 			agent.StartHealthLoop(p.ctx, p.mgr, 30*time.Second)
 		}
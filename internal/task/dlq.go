@@ -0,0 +1,264 @@
+package task
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"idra/internal/agent"
+	"idra/internal/agent/pb"
+)
+
+// DeadLetter is a task that failed permanently, kept with everything needed
+// to run it again.
+type DeadLetter struct {
+	TaskID   string          `json:"task_id"`
+	Request  *pb.TaskRequest `json:"request"`
+	Agent    string          `json:"agent,omitempty"` // target agent; empty if routed by skill
+	Timeout  agent.Duration  `json:"timeout,omitempty"`
+	Callback string          `json:"callback_url,omitempty"`
+	State    State           `json:"state"` // failed or timed_out
+	Error    string          `json:"error"`
+	Attempts []Attempt       `json:"attempts,omitempty"`
+	FailedAt time.Time       `json:"failed_at"`
+}
+
+func (f Filter) matchDead(d *DeadLetter) bool {
+	placed := d.Agent
+	if n := len(d.Attempts); placed == "" && n > 0 {
+		placed = d.Attempts[n-1].Agent
+	}
+	return (f.Agent == "" || placed == f.Agent) &&
+		(f.Skill == "" || d.Request.Skill == f.Skill) && f.allows(d.Request.Skill) &&
+		(f.State == "" || d.State == f.State) &&
+		(f.Since.IsZero() || !d.FailedAt.Before(f.Since)) &&
+		(f.Until.IsZero() || !d.FailedAt.After(f.Until))
+}
+
+// DeadLetters is the dead-letter queue: tasks that failed or timed out after
+// all retries, held until they are re-driven or discarded. With a directory
+// it is backed by dlq.log, an append-only log of additions and removals in
+// the same framing as the task store, compacted when opened.
+type DeadLetters struct {
+	mu      sync.RWMutex
+	f       *os.File // nil keeps the queue in memory only
+	path    string
+	entries map[string]*DeadLetter
+	order   []*DeadLetter // by FailedAt
+}
+
+// dlqRecord is one line of dlq.log.
+type dlqRecord struct {
+	Op     string      `json:"op"` // "add" or "remove"
+	Entry  *DeadLetter `json:"entry,omitempty"`
+	TaskID string      `json:"task_id,omitempty"`
+}
+
+// OpenDeadLetters opens (or creates) the dead-letter log in dir. An empty dir
+// keeps the queue in memory.
+func OpenDeadLetters(dir string) (*DeadLetters, error) {
+	q := &DeadLetters{entries: make(map[string]*DeadLetter)}
+	if dir == "" {
+		return q, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create dead-letter dir: %w", err)
+	}
+	q.path = filepath.Join(dir, "dlq.log")
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+	slog.Info("dead-letter queue loaded", "tasks", len(q.order))
+	return q, nil
+}
+
+// load replays the log, stopping at the first torn or corrupt record.
+func (q *DeadLetters) load() error {
+	f, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open dead-letter log: %w", err)
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				slog.Warn("dead-letter log: dropping torn record")
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read dead-letter log: %w", err)
+		}
+		var rec dlqRecord
+		payload, ok := unframe(line)
+		if !ok || json.Unmarshal(payload, &rec) != nil {
+			slog.Warn("dead-letter log: corrupt record, dropping the rest")
+			return nil
+		}
+		switch {
+		case rec.Op == "add" && rec.Entry != nil && rec.Entry.Request != nil:
+			q.insert(rec.Entry)
+		case rec.Op == "remove":
+			q.delete(rec.TaskID)
+		}
+	}
+}
+
+// compact rewrites the log with only the current entries and opens it for
+// appending.
+func (q *DeadLetters) compact() error {
+	tmp := q.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("compact dead-letter log: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for _, d := range q.order {
+		payload, err := json.Marshal(dlqRecord{Op: "add", Entry: d})
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("marshal dead letter: %w", err)
+		}
+		w.Write(frame(payload))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("compact dead-letter log: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("compact dead-letter log: %w", err)
+	}
+	f.Close()
+	if err := os.Rename(tmp, q.path); err != nil {
+		return fmt.Errorf("compact dead-letter log: %w", err)
+	}
+
+	q.f, err = os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("open dead-letter log: %w", err)
+	}
+	return nil
+}
+
+// Add puts a failed task in the queue.
+func (q *DeadLetters) Add(d DeadLetter) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.append(dlqRecord{Op: "add", Entry: &d}); err != nil {
+		return err
+	}
+	q.insert(&d)
+	return nil
+}
+
+// Remove takes a task out of the queue, reporting whether it was there.
+func (q *DeadLetters) Remove(id string) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.entries[id]; !ok {
+		return false, nil
+	}
+	if err := q.append(dlqRecord{Op: "remove", TaskID: id}); err != nil {
+		return false, err
+	}
+	q.delete(id)
+	return true, nil
+}
+
+// Get returns the dead letter for a task.
+func (q *DeadLetters) Get(id string) (DeadLetter, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	d, ok := q.entries[id]
+	if !ok {
+		return DeadLetter{}, false
+	}
+	return *d, true
+}
+
+// List returns dead letters matching f, most recently failed first. Agent
+// matches the target agent or, for skill-routed tasks, the last agent tried.
+func (q *DeadLetters) List(f Filter) []DeadLetter {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	out := make([]DeadLetter, 0)
+	for i := len(q.order) - 1; i >= 0; i-- {
+		if !f.matchDead(q.order[i]) {
+			continue
+		}
+		out = append(out, *q.order[i])
+		if f.Limit > 0 && len(out) >= f.Limit {
+			break
+		}
+	}
+	return out
+}
+
+// Close closes the log file.
+func (q *DeadLetters) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.f == nil {
+		return nil
+	}
+	return q.f.Close()
+}
+
+// append writes a record and fsyncs it. Caller must hold mu.
+func (q *DeadLetters) append(rec dlqRecord) error {
+	if q.f == nil {
+		return nil
+	}
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal dead letter: %w", err)
+	}
+	if _, err := q.f.Write(frame(payload)); err != nil {
+		return fmt.Errorf("write dead-letter log: %w", err)
+	}
+	if err := q.f.Sync(); err != nil {
+		return fmt.Errorf("sync dead-letter log: %w", err)
+	}
+	return nil
+}
+
+// insert adds or replaces an entry, keeping order sorted by FailedAt.
+func (q *DeadLetters) insert(d *DeadLetter) {
+	q.delete(d.TaskID)
+	q.entries[d.TaskID] = d
+	i := sort.Search(len(q.order), func(i int) bool { return q.order[i].FailedAt.After(d.FailedAt) })
+	q.order = append(q.order, nil)
+	copy(q.order[i+1:], q.order[i:])
+	q.order[i] = d
+}
+
+func (q *DeadLetters) delete(id string) {
+	d, ok := q.entries[id]
+	if !ok {
+		return
+	}
+	delete(q.entries, id)
+	for i, x := range q.order {
+		if x == d {
+			q.order = append(q.order[:i], q.order[i+1:]...)
+			break
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package task
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Store is an append-only task history on disk. Every state change of a
+// task appends a full record to tasks.log; the latest record for an ID wins.
+// Each line is "<crc32 hex> <json>\n" and is fsynced before Append returns.
+// On open, the log is scanned to rebuild the in-memory indexes; a torn or
+// corrupt tail left by a crash is truncated, and tasks that were still
+// queued or running are marked failed. The log is then compacted to the
+// latest record of each retained task, and again whenever superseded
+// records make up most of it.
+type Store struct {
+	mu     sync.RWMutex
+	f      *os.File
+	path   string
+	retain int                 // tasks kept, oldest finished dropped first; 0 = all
+	size   int64               // bytes in the log
+	live   int64               // bytes in the latest record of each task
+	index  map[string]*entry   // task ID → latest record
+	order  []*entry            // every task, by creation time
+	agent  map[string][]*entry // agent name → tasks, by creation time
+	skill  map[string][]*entry // skill name → tasks, by creation time
+	keys   map[string]*entry   // idempotency key → task
+}
+
+// entry locates the latest record of a task and caches the indexed fields.
+type entry struct {
+	id      string
+	agent   string
+	skill   string
+	state   State
+	created time.Time
+	offset  int64 // start of the JSON payload
+	length  int
+}
+
+// compactSlack is how many bytes of superseded records the log may hold
+// beyond its live records before Append compacts it.
+const compactSlack = 4 << 20
+
+// OpenStore opens (or creates) the task log in dir, recovers its index and
+// compacts it. Beyond retain tasks (0 = no limit), the oldest finished ones
+// are dropped.
+func OpenStore(dir string, retain int) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create task store dir: %w", err)
+	}
+	path := filepath.Join(dir, "tasks.log")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open task store: %w", err)
+	}
+
+	s := &Store{f: f, path: path, retain: retain}
+	s.reset()
+	if err := s.recover(); err != nil {
+		s.f.Close()
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		s.f.Close()
+		return nil, err
+	}
+	slog.Info("task store compacted", "tasks", len(s.index), "bytes", s.size)
+	return s, nil
+}
+
+// reset empties the in-memory indexes.
+func (s *Store) reset() {
+	s.index = make(map[string]*entry)
+	s.order = nil
+	s.agent = make(map[string][]*entry)
+	s.skill = make(map[string][]*entry)
+	s.keys = make(map[string]*entry)
+	s.live = 0
+}
+
+// recover rebuilds the index from the log and repairs the tail.
+func (s *Store) recover() error {
+	r := bufio.NewReader(s.f)
+	var offset int64
+	var interrupted []string
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				slog.Warn("task store: dropping torn record", "offset", offset)
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read task store: %w", err)
+		}
+
+		t, payloadOff, ok := decodeRecord(line)
+		if !ok {
+			// Everything after a corrupt record is suspect; cut it off.
+			slog.Warn("task store: corrupt record, truncating", "offset", offset)
+			break
+		}
+		s.indexRecord(t, offset+int64(payloadOff), len(line)-payloadOff-1)
+		offset += int64(len(line))
+	}
+
+	if err := s.f.Truncate(offset); err != nil {
+		return fmt.Errorf("truncate task store: %w", err)
+	}
+	if _, err := s.f.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("seek task store: %w", err)
+	}
+	s.size = offset
+
+	for id, e := range s.index {
+		if !e.state.Terminal() {
+			interrupted = append(interrupted, id)
+		}
+	}
+	for _, id := range interrupted {
+		t, err := s.read(s.index[id])
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		t.State = StateFailed
+		t.Error = "interrupted: idra stopped before the task finished"
+		t.FinishedAt = &now
+		if err := s.Append(t); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("task store loaded", "tasks", len(s.index), "interrupted", len(interrupted))
+	return nil
+}
+
+// Append writes a record for t and fsyncs it.
+func (s *Store) Append(t Task) error {
+	payload, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("marshal task: %w", err)
+	}
+	line := frame(payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.f.Write(line); err != nil {
+		return fmt.Errorf("write task store: %w", err)
+	}
+	if err := s.f.Sync(); err != nil {
+		return fmt.Errorf("sync task store: %w", err)
+	}
+	s.indexRecord(t, s.size+prefixLen, len(payload))
+	s.size += int64(len(line))
+
+	if s.size > 2*s.live+compactSlack || (s.retain > 0 && len(s.order) > s.retain+s.retain/4) {
+		// The record is already safe in the log; a failed compaction
+		// leaves the log as it was.
+		if err := s.compact(); err != nil {
+			slog.Error("task store compaction failed", "error", err)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the log with only the latest record of each task,
+// dropping the oldest finished tasks beyond the retention limit, and
+// reindexes it. Caller must hold mu (or be the only user, while opening).
+func (s *Store) compact() error {
+	keep := s.order
+	if drop := len(s.order) - s.retain; s.retain > 0 && drop > 0 {
+		keep = make([]*entry, 0, len(s.order)-drop)
+		for _, e := range s.order {
+			if drop > 0 && e.state.Terminal() {
+				drop--
+				continue
+			}
+			keep = append(keep, e)
+		}
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("compact task store: %w", err)
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("compact task store: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	tasks := make([]Task, 0, len(keep))
+	for _, e := range keep {
+		payload := make([]byte, e.length)
+		if _, err := s.f.ReadAt(payload, e.offset); err != nil {
+			return fail(err)
+		}
+		var t Task
+		if err := json.Unmarshal(payload, &t); err != nil {
+			return fail(fmt.Errorf("decode task %s: %w", e.id, err))
+		}
+		tasks = append(tasks, t)
+		w.Write(frame(payload))
+	}
+	if err := w.Flush(); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	f.Close()
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("compact task store: %w", err)
+	}
+
+	// Until the new log is open, the old file and index stay usable.
+	nf, err := os.OpenFile(s.path, os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("open task store: %w", err)
+	}
+	size, err := nf.Seek(0, io.SeekEnd)
+	if err != nil {
+		nf.Close()
+		return fmt.Errorf("seek task store: %w", err)
+	}
+	s.f.Close()
+	s.f = nf
+	s.size = size
+
+	s.reset()
+	var offset int64
+	for i, t := range tasks {
+		n := keep[i].length
+		s.indexRecord(t, offset+prefixLen, n)
+		offset += int64(prefixLen + n + 1)
+	}
+	return nil
+}
+
+// Get loads the latest record of a task.
+func (s *Store) Get(id string) (Task, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.index[id]
+	if !ok {
+		return Task{}, false, nil
+	}
+	t, err := s.read(e)
+	return t, err == nil, err
+}
+
+// GetByKey loads the task recorded with an idempotency key.
+func (s *Store) GetByKey(key string) (Task, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.keys[key]
+	if !ok {
+		return Task{}, false, nil
+	}
+	t, err := s.read(e)
+	return t, err == nil, err
+}
+
+// Query returns tasks matching f, newest first, loading each from disk.
+func (s *Store) Query(f Filter) ([]Task, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Scan the smallest applicable index.
+	candidates := s.order
+	if f.Agent != "" && (f.Skill == "" || len(s.agent[f.Agent]) <= len(s.skill[f.Skill])) {
+		candidates = s.agent[f.Agent]
+	} else if f.Skill != "" {
+		candidates = s.skill[f.Skill]
+	}
+
+	// Indexes are sorted by creation time, so Until bounds the scan.
+	end := len(candidates)
+	if !f.Until.IsZero() {
+		end = sort.Search(len(candidates), func(i int) bool {
+			return candidates[i].created.After(f.Until)
+		})
+	}
+
+	out := make([]Task, 0)
+	for i := end - 1; i >= 0; i-- {
+		e := candidates[i]
+		if !f.Since.IsZero() && e.created.Before(f.Since) {
+			break
+		}
+		if !f.matchEntry(e) {
+			continue
+		}
+		t, err := s.read(e)
+		if err != nil {
+			return out, err
+		}
+		out = append(out, t)
+		if f.Limit > 0 && len(out) >= f.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+// Close closes the log file.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
+
+// indexRecord points the index at a new record for t. Caller must hold mu
+// (or be the only user, during recovery).
+func (s *Store) indexRecord(t Task, offset int64, length int) {
+	e, ok := s.index[t.ID]
+	if !ok {
+		e = &entry{id: t.ID, skill: t.Skill, created: t.CreatedAt}
+		s.index[t.ID] = e
+		s.live += prefixLen + 1
+		s.order = insertByCreated(s.order, e)
+		s.skill[t.Skill] = insertByCreated(s.skill[t.Skill], e)
+		if t.IdempotencyKey != "" {
+			s.keys[t.IdempotencyKey] = e
+		}
+	}
+	if t.Agent != "" && t.Agent != e.agent {
+		if e.agent != "" {
+			s.agent[e.agent] = removeEntry(s.agent[e.agent], e)
+		}
+		e.agent = t.Agent
+		s.agent[t.Agent] = insertByCreated(s.agent[t.Agent], e)
+	}
+	s.live += int64(length - e.length) // e.length is 0 for a new entry
+	e.state = t.State
+	e.offset = offset
+	e.length = length
+}
+
+// read loads the record e points at. Caller must hold mu.
+func (s *Store) read(e *entry) (Task, error) {
+	buf := make([]byte, e.length)
+	if _, err := s.f.ReadAt(buf, e.offset); err != nil {
+		return Task{}, fmt.Errorf("read task %s: %w", e.id, err)
+	}
+	var t Task
+	if err := json.Unmarshal(buf, &t); err != nil {
+		return Task{}, fmt.Errorf("decode task %s: %w", e.id, err)
+	}
+	return t, nil
+}
+
+// prefixLen is the length of a record's "<crc32 hex> " prefix.
+const prefixLen = 9
+
+// frame returns payload as a "<crc32 hex> <payload>\n" log line.
+func frame(payload []byte) []byte {
+	line := make([]byte, 0, prefixLen+len(payload)+1)
+	line = fmt.Appendf(line, "%08x ", crc32.ChecksumIEEE(payload))
+	line = append(line, payload...)
+	return append(line, '\n')
+}
+
+// unframe verifies a log line written by frame and returns its payload.
+func unframe(line []byte) ([]byte, bool) {
+	if len(line) < prefixLen+2 || line[prefixLen-1] != ' ' {
+		return nil, false
+	}
+	want, err := strconv.ParseUint(string(line[:prefixLen-1]), 16, 32)
+	if err != nil {
+		return nil, false
+	}
+	payload := line[prefixLen : len(line)-1]
+	if crc32.ChecksumIEEE(payload) != uint32(want) {
+		return nil, false
+	}
+	return payload, true
+}
+
+// decodeRecord verifies a task log line and returns the task and the offset
+// of the JSON payload within the line.
+func decodeRecord(line []byte) (Task, int, bool) {
+	payload, ok := unframe(line)
+	if !ok {
+		return Task{}, 0, false
+	}
+	var t Task
+	if err := json.Unmarshal(payload, &t); err != nil || t.ID == "" {
+		return Task{}, 0, false
+	}
+	return t, prefixLen, true
+}
+
+// insertByCreated inserts e keeping list sorted by creation time.
+func insertByCreated(list []*entry, e *entry) []*entry {
+	i := sort.Search(len(list), func(i int) bool { return list[i].created.After(e.created) })
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = e
+	return list
+}
+
+func removeEntry(list []*entry, e *entry) []*entry {
+	for i, x := range list {
+		if x == e {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
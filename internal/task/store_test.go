@@ -0,0 +1,221 @@
+package task
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func testTask(i int, state State) Task {
+	return Task{
+		ID:        fmt.Sprintf("task-%d", i),
+		Agent:     "echo",
+		Skill:     "summarize",
+		State:     state,
+		CreatedAt: epoch.Add(time.Duration(i) * time.Second),
+	}
+}
+
+func openTestStore(t *testing.T, dir string, retain int) *Store {
+	t.Helper()
+	s, err := OpenStore(dir, retain)
+	if err != nil {
+		t.Fatalf("OpenStore() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStoreRecoversTornTail(t *testing.T) {
+	tests := []struct {
+		name string
+		tail []byte // written after two good records
+	}{
+		{"clean", nil},
+		{"torn record", []byte(`8badf00d {"id":"task-9","sk`)},
+		{"bad checksum", append([]byte(`00000000 {"id":"task-9","state":"succeeded"}`), '\n')},
+		{"garbage line", []byte("not a record\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openTestStore(t, dir, 0)
+			for i := range 2 {
+				if err := s.Append(testTask(i, StateSucceeded)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s.Close()
+
+			path := filepath.Join(dir, "tasks.log")
+			good, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, append(good, tt.tail...), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			s = openTestStore(t, dir, 0)
+			got, err := s.Query(Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2 || got[0].ID != "task-1" || got[1].ID != "task-0" {
+				t.Fatalf("Query() = %v, want task-1, task-0", ids(got))
+			}
+			after, _ := os.ReadFile(path)
+			if string(after) != string(good) {
+				t.Errorf("log after recovery = %q, want %q", after, good)
+			}
+			// New records land after the repaired tail.
+			if err := s.Append(testTask(2, StateSucceeded)); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok, err := s.Get("task-2"); !ok || err != nil {
+				t.Errorf("Get(task-2) = %v, %v after append", ok, err)
+			}
+		})
+	}
+}
+
+func TestStoreMarksInterruptedTasksFailed(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, 0)
+	for i, state := range []State{StateQueued, StateRunning, StateSucceeded} {
+		if err := s.Append(testTask(i, state)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+
+	s = openTestStore(t, dir, 0)
+	want := map[string]State{"task-0": StateFailed, "task-1": StateFailed, "task-2": StateSucceeded}
+	for id, state := range want {
+		got, ok, err := s.Get(id)
+		if !ok || err != nil || got.State != state {
+			t.Errorf("Get(%s) = %q, %v, %v; want %q", id, got.State, ok, err, state)
+		}
+	}
+}
+
+func TestStoreCompaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		retain  int
+		states  []State // one task each, oldest first
+		wantIDs []string
+	}{
+		{"keeps everything without a limit", 0, []State{StateSucceeded, StateFailed, StateCancelled}, []string{"task-2", "task-1", "task-0"}},
+		{"drops the oldest finished", 2, []State{StateSucceeded, StateFailed, StateCancelled}, []string{"task-2", "task-1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			s := openTestStore(t, dir, 0)
+			for i, state := range tt.states {
+				// Superseded records: every task passes through running.
+				running := testTask(i, StateRunning)
+				running.IdempotencyKey = fmt.Sprintf("key-%d", i)
+				if err := s.Append(running); err != nil {
+					t.Fatal(err)
+				}
+				done := running
+				done.State = state
+				if err := s.Append(done); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s.Close()
+
+			s = openTestStore(t, dir, tt.retain)
+			got, err := s.Query(Filter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(ids(got)) != fmt.Sprint(tt.wantIDs) {
+				t.Fatalf("Query() = %v, want %v", ids(got), tt.wantIDs)
+			}
+			data, err := os.ReadFile(filepath.Join(dir, "tasks.log"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lines := countLines(data); lines != len(tt.wantIDs) {
+				t.Errorf("log has %d records, want %d", lines, len(tt.wantIDs))
+			}
+			if _, err := os.Stat(filepath.Join(dir, "tasks.log.tmp")); !os.IsNotExist(err) {
+				t.Errorf("temp file left behind: %v", err)
+			}
+			for _, id := range tt.wantIDs {
+				var i int
+				fmt.Sscanf(id, "task-%d", &i)
+				if got, ok, err := s.GetByKey(fmt.Sprintf("key-%d", i)); !ok || err != nil || got.ID != id {
+					t.Errorf("GetByKey(key-%d) = %q, %v, %v", i, got.ID, ok, err)
+				}
+			}
+		})
+	}
+}
+
+func TestStoreRetentionKeepsUnfinishedTasks(t *testing.T) {
+	s := openTestStore(t, t.TempDir(), 2)
+	for i, state := range []State{StateQueued, StateSucceeded, StateSucceeded, StateSucceeded} {
+		if err := s.Append(testTask(i, state)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := s.Query(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"task-3", "task-0"}; fmt.Sprint(ids(got)) != fmt.Sprint(want) {
+		t.Errorf("Query() = %v, want %v", ids(got), want)
+	}
+}
+
+func TestStoreCompactsWhileRunning(t *testing.T) {
+	dir := t.TempDir()
+	s := openTestStore(t, dir, 0)
+	tk := testTask(0, StateRunning)
+	tk.Input = strings.Repeat("x", 64<<10)
+	// Rewriting one 64 KiB task well past compactSlack must not grow the log
+	// without bound.
+	for range 2 * compactSlack / (64 << 10) {
+		if err := s.Append(tk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.size > 2*s.live+compactSlack {
+		t.Errorf("log is %d bytes for %d live bytes", s.size, s.live)
+	}
+	info, err := os.Stat(filepath.Join(dir, "tasks.log"))
+	if err != nil || info.Size() != s.size {
+		t.Errorf("log size on disk = %v, %v; want %d", info.Size(), err, s.size)
+	}
+	if got, ok, err := s.Get(tk.ID); !ok || err != nil || len(got.Input) != len(tk.Input) {
+		t.Errorf("Get() after compaction = %v, %v", ok, err)
+	}
+}
+
+func ids(tasks []Task) []string {
+	out := make([]string, len(tasks))
+	for i, t := range tasks {
+		out[i] = t.ID
+	}
+	return out
+}
+
+func countLines(data []byte) int {
+	n := 0
+	for _, b := range data {
+		if b == '\n' {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,452 @@
+// Package task tracks the lifecycle of tasks submitted to the agent fleet so
+// that they can be inspected after (or while) they run.
+package task
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"idra/internal/agent/pb"
+	"idra/internal/webhook"
+)
+
+// State is the lifecycle state of a task.
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+	StateCancelled State = "cancelled"
+	StateTimedOut  State = "timed_out"
+)
+
+// Terminal reports whether the task has finished.
+func (s State) Terminal() bool {
+	return s == StateSucceeded || s == StateFailed || s == StateCancelled || s == StateTimedOut
+}
+
+// Task is the record of one task submission.
+type Task struct {
+	ID       string            `json:"id"`
+	Agent    string            `json:"agent,omitempty"` // empty until a skill-routed task is placed
+	Skill    string            `json:"skill"`
+	Input    string            `json:"input,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+
+	State    State           `json:"state"`
+	Error    string          `json:"error,omitempty"`
+	Events   []*pb.TaskEvent `json:"events,omitempty"`
+	Attempts []Attempt       `json:"attempts,omitempty"`
+	Callback *Callback       `json:"callback,omitempty"`
+	Cached   bool            `json:"cached,omitempty"` // events replayed from the result cache
+
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Attempt records one try at executing a task. Retries and failover to
+// another provider add further attempts.
+type Attempt struct {
+	Agent      string    `json:"agent,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Class      string    `json:"class,omitempty"` // error class, see agent.Classify
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+}
+
+// Callback is the completion webhook of a task and its delivery log.
+type Callback struct {
+	URL        string            `json:"url"`
+	Deliveries []webhook.Attempt `json:"deliveries,omitempty"`
+}
+
+// Filter selects tasks in List. Zero values match everything.
+type Filter struct {
+	Agent  string
+	Skill  string
+	Skills []string // skills allowed, e.g. by the caller's token; empty = all
+	State  State
+	Since  time.Time // created at or after Since
+	Until  time.Time // created at or before Until
+	Limit  int       // maximum number of tasks returned, newest first
+}
+
+func (f Filter) allows(skill string) bool {
+	return len(f.Skills) == 0 || slices.Contains(f.Skills, skill)
+}
+
+func (f Filter) match(t *Task) bool {
+	return (f.Agent == "" || t.Agent == f.Agent) &&
+		(f.Skill == "" || t.Skill == f.Skill) && f.allows(t.Skill) &&
+		(f.State == "" || t.State == f.State) &&
+		(f.Since.IsZero() || !t.CreatedAt.Before(f.Since)) &&
+		(f.Until.IsZero() || !t.CreatedAt.After(f.Until))
+}
+
+func (f Filter) matchEntry(e *entry) bool {
+	return (f.Agent == "" || e.agent == f.Agent) &&
+		(f.Skill == "" || e.skill == f.Skill) && f.allows(e.skill) &&
+		(f.State == "" || e.state == f.State)
+}
+
+// Tracker keeps recent tasks in memory. Once more than limit tasks are held,
+// the oldest finished ones are evicted. With a Store, every state change is
+// also persisted and lookups fall back to the store's full history.
+type Tracker struct {
+	mu    sync.RWMutex
+	tasks map[string]*Task
+	order []string // task IDs in creation order
+	limit int
+	store *Store // nil keeps history in memory only
+	live  map[string]*liveTask
+	keys  map[string]string // idempotency key → task ID, for tasks in memory
+}
+
+// liveTask is the cancellation handle of a task that has not finished.
+type liveTask struct {
+	cancel    context.CancelFunc // drops the task's stream
+	done      chan struct{}      // closed by Finish
+	requested bool               // RequestCancel was called
+}
+
+// NewTracker creates a tracker that retains up to limit tasks in memory.
+// store may be nil.
+func NewTracker(limit int, store *Store) *Tracker {
+	return &Tracker{
+		tasks: make(map[string]*Task),
+		limit: limit,
+		store: store,
+		live:  make(map[string]*liveTask),
+		keys:  make(map[string]string),
+	}
+}
+
+// Create records a new queued task for req. agentName may be empty when the
+// agent is chosen later by skill routing. cancel, if set, aborts the context
+// the task runs under and is used by RequestCancel.
+//
+// If key is not empty and a task with the same idempotency key exists, that
+// task is returned with created set to false and nothing is recorded.
+func (t *Tracker) Create(req *pb.TaskRequest, agentName, key string, cancel context.CancelFunc) (tk Task, created bool) {
+	rec := &Task{
+		ID:             req.TaskId,
+		Agent:          agentName,
+		Skill:          req.Skill,
+		Input:          req.Input,
+		Metadata:       req.Metadata,
+		IdempotencyKey: key,
+		State:          StateQueued,
+		CreatedAt:      time.Now().UTC(),
+	}
+
+	t.mu.Lock()
+	if key != "" {
+		if prev, ok := t.byKey(key); ok {
+			t.mu.Unlock()
+			return prev, false
+		}
+		t.keys[key] = rec.ID
+	}
+	t.tasks[rec.ID] = rec
+	t.order = append(t.order, rec.ID)
+	t.live[rec.ID] = &liveTask{cancel: cancel, done: make(chan struct{})}
+	t.evict()
+	snap := rec.snapshot(true)
+	t.mu.Unlock()
+
+	t.persist(snap)
+	return snap, true
+}
+
+// Lookup returns the task with the given idempotency key.
+func (t *Tracker) Lookup(key string) (Task, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.byKey(key)
+}
+
+// byKey finds the task with an idempotency key in memory or in the store.
+// Caller must hold mu.
+func (t *Tracker) byKey(key string) (Task, bool) {
+	if id, ok := t.keys[key]; ok {
+		if tk, ok := t.tasks[id]; ok {
+			return tk.snapshot(true), true
+		}
+	}
+	if t.store == nil {
+		return Task{}, false
+	}
+	tk, ok, err := t.store.GetByKey(key)
+	if err != nil {
+		slog.Warn("task store lookup failed", "idempotency_key", key, "error", err)
+	}
+	return tk, ok
+}
+
+// Start marks the task as running on the named agent, once it has left the
+// agent's queue.
+func (t *Tracker) Start(id, agentName string) {
+	t.updateAndPersist(id, func(tk *Task) {
+		now := time.Now().UTC()
+		tk.State = StateRunning
+		tk.StartedAt = &now
+		tk.Agent = agentName
+	})
+}
+
+// SetAgent records the agent a skill-routed task was placed on.
+func (t *Tracker) SetAgent(id, agentName string) {
+	t.update(id, func(tk *Task) { tk.Agent = agentName })
+}
+
+// AddEvent appends an event streamed by the agent.
+func (t *Tracker) AddEvent(id string, ev *pb.TaskEvent) {
+	t.update(id, func(tk *Task) { tk.Events = append(tk.Events, ev) })
+}
+
+// SetCallback sets the URL notified when the task finishes.
+func (t *Tracker) SetCallback(id, url string) {
+	t.update(id, func(tk *Task) { tk.Callback = &Callback{URL: url} })
+}
+
+// SetCached marks the task as answered from the result cache.
+func (t *Tracker) SetCached(id string) {
+	t.update(id, func(tk *Task) { tk.Cached = true })
+}
+
+// AddDelivery records an attempt to deliver the task's callback. Unlike the
+// other updates it may arrive after the task was evicted from memory, in
+// which case the stored record is amended.
+func (t *Tracker) AddDelivery(id string, a webhook.Attempt) {
+	add := func(tk *Task) {
+		if tk.Callback != nil {
+			tk.Callback.Deliveries = append(tk.Callback.Deliveries, a)
+		}
+	}
+	t.mu.RLock()
+	_, inMemory := t.tasks[id]
+	t.mu.RUnlock()
+	if inMemory || t.store == nil {
+		t.updateAndPersist(id, add)
+		return
+	}
+	tk, ok, err := t.store.Get(id)
+	if err != nil || !ok {
+		slog.Warn("record webhook delivery: task not found", "task_id", id, "error", err)
+		return
+	}
+	add(&tk)
+	t.persist(tk)
+}
+
+// AddAttempt records a finished execution attempt.
+func (t *Tracker) AddAttempt(id string, a Attempt) {
+	t.update(id, func(tk *Task) { tk.Attempts = append(tk.Attempts, a) })
+}
+
+// Finish marks the task as done. It failed if err is set or the agent's
+// last event is an "error" event, and was cancelled or timed out if the
+// last event is a "cancelled" or "timeout" event. It returns the final
+// record.
+func (t *Tracker) Finish(id string, err error) Task {
+	snap := t.updateAndPersist(id, func(tk *Task) {
+		now := time.Now().UTC()
+		tk.FinishedAt = &now
+		tk.State = StateSucceeded
+		if err != nil {
+			tk.State = StateFailed
+			tk.Error = err.Error()
+		} else if n := len(tk.Events); n > 0 && tk.Events[n-1].Type == "error" {
+			tk.State = StateFailed
+			tk.Error = tk.Events[n-1].Payload
+		} else if n > 0 && tk.Events[n-1].Type == "cancelled" {
+			tk.State = StateCancelled
+		} else if n > 0 && tk.Events[n-1].Type == "timeout" {
+			tk.State = StateTimedOut
+			tk.Error = tk.Events[n-1].Payload
+		}
+	})
+
+	t.mu.Lock()
+	if lt, ok := t.live[id]; ok {
+		close(lt.done)
+		delete(t.live, id)
+	}
+	t.mu.Unlock()
+	return snap
+}
+
+// RequestCancel marks an unfinished task as cancelled by request. It returns
+// a channel closed when the task finishes and a function that aborts the
+// task's context; ok is false if the task is not running in this process.
+func (t *Tracker) RequestCancel(id string) (done <-chan struct{}, abort func(), ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	lt, ok := t.live[id]
+	if !ok {
+		return nil, nil, false
+	}
+	lt.requested = true
+	abort = func() {}
+	if lt.cancel != nil {
+		abort = lt.cancel
+	}
+	return lt.done, abort, true
+}
+
+// CancelRequested reports whether RequestCancel was called for the task.
+func (t *Tracker) CancelRequested(id string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	lt, ok := t.live[id]
+	return ok && lt.requested
+}
+
+// Get returns a copy of the task with the given ID.
+func (t *Tracker) Get(id string) (Task, bool) {
+	t.mu.RLock()
+	tk, ok := t.tasks[id]
+	var snap Task
+	if ok {
+		snap = tk.snapshot(true)
+	}
+	t.mu.RUnlock()
+	if ok || t.store == nil {
+		return snap, ok
+	}
+
+	snap, ok, err := t.store.Get(id)
+	if err != nil {
+		slog.Warn("task store lookup failed", "task_id", id, "error", err)
+	}
+	return snap, ok
+}
+
+// List returns tasks matching f, newest first. Events are omitted.
+func (t *Tracker) List(f Filter) []Task {
+	if t.store != nil {
+		return t.listStored(f)
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	out := make([]Task, 0)
+	for i := len(t.order) - 1; i >= 0; i-- {
+		tk := t.tasks[t.order[i]]
+		if !f.match(tk) {
+			continue
+		}
+		out = append(out, tk.snapshot(false))
+		if f.Limit > 0 && len(out) >= f.Limit {
+			break
+		}
+	}
+	return out
+}
+
+// listStored queries the store, preferring the in-memory copy of tasks that
+// are still being updated.
+func (t *Tracker) listStored(f Filter) []Task {
+	out, err := t.store.Query(f)
+	if err != nil {
+		slog.Warn("task store query failed", "error", err)
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for i := range out {
+		if tk, ok := t.tasks[out[i].ID]; ok {
+			out[i] = tk.snapshot(false)
+		} else {
+			out[i].Events = nil
+		}
+	}
+	return out
+}
+
+func (t *Tracker) persist(tk Task) {
+	if t.store == nil {
+		return
+	}
+	if err := t.store.Append(tk); err != nil {
+		slog.Error("persist task", "task_id", tk.ID, "error", err)
+	}
+}
+
+func (t *Tracker) update(id string, fn func(*Task)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tk, ok := t.tasks[id]; ok {
+		fn(tk)
+	}
+}
+
+// updateAndPersist applies fn, appends the resulting state to the store and
+// returns it.
+func (t *Tracker) updateAndPersist(id string, fn func(*Task)) Task {
+	t.mu.Lock()
+	tk, ok := t.tasks[id]
+	var snap Task
+	if ok {
+		fn(tk)
+		snap = tk.snapshot(true)
+	}
+	t.mu.Unlock()
+	if ok {
+		t.persist(snap)
+	}
+	return snap
+}
+
+// evict drops the oldest finished tasks while over the limit. Caller must
+// hold mu.
+func (t *Tracker) evict() {
+	for i := 0; len(t.tasks) > t.limit && i < len(t.order); {
+		id := t.order[i]
+		if !t.tasks[id].State.Terminal() {
+			i++
+			continue
+		}
+		if key := t.tasks[id].IdempotencyKey; key != "" {
+			delete(t.keys, key)
+		}
+		delete(t.tasks, id)
+		t.order = append(t.order[:i], t.order[i+1:]...)
+	}
+}
+
+// snapshot copies the task so callers can't race with updates.
+func (tk *Task) snapshot(withEvents bool) Task {
+	c := *tk
+	c.Events = nil
+	if withEvents {
+		c.Events = append([]*pb.TaskEvent(nil), tk.Events...)
+	}
+	c.Attempts = slices.Clone(tk.Attempts)
+	if tk.Callback != nil {
+		cb := *tk.Callback
+		cb.Deliveries = slices.Clone(cb.Deliveries)
+		c.Callback = &cb
+	}
+	return c
+}
+
+// ParseState validates a state name from a query string.
+func ParseState(s string) (State, error) {
+	switch st := State(s); st {
+	case "", StateQueued, StateRunning, StateSucceeded, StateFailed, StateCancelled, StateTimedOut:
+		return st, nil
+	default:
+		return "", fmt.Errorf("unknown task state %q", s)
+	}
+}
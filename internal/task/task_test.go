@@ -0,0 +1,46 @@
+package task
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"idra/internal/agent/pb"
+)
+
+func TestListSkills(t *testing.T) {
+	// Newest first: task-5 translate, task-4 summarize, task-3 translate, ...
+	skills := []string{"summarize", "translate", "summarize", "translate", "summarize", "translate"}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"no allow-list", Filter{Limit: 2}, []string{"task-5", "task-4"}},
+		{"allowed before the limit", Filter{Skills: []string{"summarize"}, Limit: 2}, []string{"task-4", "task-2"}},
+		{"several allowed", Filter{Skills: []string{"summarize", "translate"}, Limit: 3}, []string{"task-5", "task-4", "task-3"}},
+		{"skill outside the allow-list", Filter{Skill: "translate", Skills: []string{"summarize"}}, nil},
+		{"none allowed", Filter{Skills: []string{"sentiment"}}, nil},
+	}
+	for _, backend := range []string{"memory", "store"} {
+		var store *Store
+		if backend == "store" {
+			store = openTestStore(t, t.TempDir(), 0)
+		}
+		tr := NewTracker(100, store)
+		for i, skill := range skills {
+			tr.Create(&pb.TaskRequest{TaskId: fmt.Sprintf("task-%d", i), Skill: skill}, "echo", "", nil)
+		}
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.name, func(t *testing.T) {
+				var got []string
+				for _, tk := range tr.List(tt.filter) {
+					got = append(got, tk.ID)
+				}
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("List() = %v, want %v", got, tt.want)
+				}
+			})
+		}
+	}
+}
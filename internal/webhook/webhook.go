@@ -0,0 +1,185 @@
+// Package webhook delivers signed HTTP callbacks when tasks finish.
+//
+// Every delivery is a POST with a JSON body and these headers:
+//
+//	X-Idra-Event:     the event name, e.g. "task.finished"
+//	X-Idra-Delivery:  an ID that stays the same across retries
+//	X-Idra-Signature: t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+//
+// The timestamp is part of the signed message so receivers can reject
+// replayed deliveries; see Verify.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Idra-Signature"
+	EventHeader     = "X-Idra-Event"
+	DeliveryHeader  = "X-Idra-Delivery"
+)
+
+// Sign returns the signature header value for body sent at t.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + mac(secret, ts, body)
+}
+
+func mac(secret, ts string, body []byte) string {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(ts))
+	h.Write([]byte{'.'})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Verify checks a signature header against body. Deliveries signed more
+// than tolerance away from now are rejected; a zero tolerance skips the
+// check.
+func Verify(secret, header string, body []byte, tolerance time.Duration) error {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(part, "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	if ts == "" || sig == "" {
+		return errors.New("malformed signature header")
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("malformed signature timestamp")
+	}
+	if tolerance > 0 {
+		if d := time.Since(time.Unix(sec, 0)); d > tolerance || d < -tolerance {
+			return errors.New("signature timestamp outside tolerance")
+		}
+	}
+	if !hmac.Equal([]byte(sig), []byte(mac(secret, ts, body))) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+// Attempt records one delivery attempt.
+type Attempt struct {
+	Delivery string    `json:"delivery"`
+	Attempt  int       `json:"attempt"` // 1-based
+	At       time.Time `json:"at"`
+	Status   int       `json:"status,omitempty"` // HTTP status, 0 if no response
+	Duration string    `json:"duration"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// Sender posts signed payloads, retrying failed deliveries with exponential
+// backoff. Network errors, 429 and 5xx responses are retried; any other
+// non-2xx response is final.
+type Sender struct {
+	Client      *http.Client
+	MaxAttempts int           // total attempts including the first
+	Backoff     time.Duration // delay before the first retry, doubled after each
+	MaxBackoff  time.Duration
+}
+
+// NewSender returns a sender with a 10s request timeout and 6 attempts,
+// backing off from 1s up to 1m.
+func NewSender() *Sender {
+	return &Sender{
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 6,
+		Backoff:     time.Second,
+		MaxBackoff:  time.Minute,
+	}
+}
+
+// Send delivers body to url as event, signed with secret, and returns once
+// it was accepted, failed permanently or ran out of attempts. onAttempt, if
+// set, is called after every attempt.
+func (s *Sender) Send(ctx context.Context, url, secret, event string, body []byte, onAttempt func(Attempt)) error {
+	delivery := newDeliveryID()
+	backoff := s.Backoff
+	var err error
+	for n := 1; ; n++ {
+		var retry bool
+		retry, err = s.attempt(ctx, url, secret, event, delivery, n, body, onAttempt)
+		if err == nil || !retry || n >= s.MaxAttempts {
+			return err
+		}
+		t := time.NewTimer(backoff)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		}
+		backoff = min(backoff*2, s.MaxBackoff)
+	}
+}
+
+// attempt makes one delivery and reports whether a failure may be retried.
+func (s *Sender) attempt(ctx context.Context, url, secret, event, delivery string, n int, body []byte, onAttempt func(Attempt)) (bool, error) {
+	start := time.Now()
+	a := Attempt{Delivery: delivery, Attempt: n, At: start.UTC()}
+	retry, err := func() (bool, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "idra-webhook")
+		req.Header.Set(EventHeader, event)
+		req.Header.Set(DeliveryHeader, delivery)
+		req.Header.Set(SignatureHeader, Sign(secret, start, body))
+
+		resp, err := s.Client.Do(req)
+		if err != nil {
+			return true, err
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		a.Status = resp.StatusCode
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return false, nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			return true, fmt.Errorf("receiver returned %s", resp.Status)
+		default:
+			return false, fmt.Errorf("receiver returned %s", resp.Status)
+		}
+	}()
+	a.Duration = time.Since(start).Round(time.Millisecond).String()
+	if err != nil {
+		a.Error = err.Error()
+	}
+
+	slog.Info("webhook delivery attempt",
+		"url", url, "event", event, "delivery", delivery, "attempt", n, "status", a.Status, "duration", a.Duration, "error", a.Error)
+	if onAttempt != nil {
+		onAttempt(a)
+	}
+	return retry, err
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("dlv-%x", b)
+}
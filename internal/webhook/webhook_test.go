@@ -0,0 +1,119 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	body := []byte(`{"id":"task-1"}`)
+	now := time.Now()
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		body    []byte
+		wantErr bool
+	}{
+		{"round trip", "s3cr3t", Sign("s3cr3t", now, body), body, false},
+		{"wrong secret", "other", Sign("s3cr3t", now, body), body, true},
+		{"tampered body", "s3cr3t", Sign("s3cr3t", now, body), []byte(`{"id":"task-2"}`), true},
+		{"stale timestamp", "s3cr3t", Sign("s3cr3t", now.Add(-10*time.Minute), body), body, true},
+		{"future timestamp", "s3cr3t", Sign("s3cr3t", now.Add(10*time.Minute), body), body, true},
+		{"malformed header", "s3cr3t", "v1=abc", body, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, tt.body, 5*time.Minute)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// receiver answers deliveries with the given statuses in turn, repeating
+// the last one, and records what it received.
+type receiver struct {
+	mu         sync.Mutex
+	statuses   []int
+	deliveries []string
+	verifyErrs []error
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.deliveries = append(rc.deliveries, r.Header.Get(DeliveryHeader))
+	rc.verifyErrs = append(rc.verifyErrs, Verify("s3cr3t", r.Header.Get(SignatureHeader), body, time.Minute))
+	status := rc.statuses[min(len(rc.deliveries), len(rc.statuses))-1]
+	w.WriteHeader(status)
+}
+
+func TestSendRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+		wantErr      bool
+	}{
+		{"accepted at once", []int{200}, 1, false},
+		{"retries 5xx", []int{500, 503, 204}, 3, false},
+		{"retries 429", []int{429, 200}, 2, false},
+		{"no retry on 400", []int{400, 200}, 1, true},
+		{"no retry on 404", []int{404, 200}, 1, true},
+		{"gives up after the last attempt", []int{502}, 4, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &receiver{statuses: tt.statuses}
+			srv := httptest.NewServer(rc)
+			defer srv.Close()
+
+			s := &Sender{Client: srv.Client(), MaxAttempts: 4, Backoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond}
+			var attempts []Attempt
+			err := s.Send(context.Background(), srv.URL, "s3cr3t", "task.finished", []byte(`{}`), func(a Attempt) {
+				attempts = append(attempts, a)
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(attempts) != tt.wantAttempts || len(rc.deliveries) != tt.wantAttempts {
+				t.Fatalf("attempts = %d, received = %d, want %d", len(attempts), len(rc.deliveries), tt.wantAttempts)
+			}
+			for i, a := range attempts {
+				if a.Attempt != i+1 {
+					t.Errorf("attempt %d numbered %d", i+1, a.Attempt)
+				}
+				if a.Delivery != attempts[0].Delivery || rc.deliveries[i] != a.Delivery {
+					t.Errorf("attempt %d delivery ID %q, want %q on every attempt", i+1, a.Delivery, attempts[0].Delivery)
+				}
+				if rc.verifyErrs[i] != nil {
+					t.Errorf("attempt %d signature: %v", i+1, rc.verifyErrs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSendStopsOnCancel(t *testing.T) {
+	srv := httptest.NewServer(&receiver{statuses: []int{503}})
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &Sender{Client: srv.Client(), MaxAttempts: 10, Backoff: time.Hour, MaxBackoff: time.Hour}
+	n := 0
+	err := s.Send(ctx, srv.URL, "s3cr3t", "task.finished", []byte(`{}`), func(Attempt) {
+		n++
+		cancel()
+	})
+	if err == nil || n != 1 {
+		t.Errorf("Send() = %v after %d attempts, want an error after 1", err, n)
+	}
+}
@@ -0,0 +1,314 @@
+package workflow
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+
+	"idra/internal/agent/pb"
+)
+
+// RunState is the lifecycle state of a workflow run or one of its steps.
+type RunState string
+
+const (
+	StatePending   RunState = "pending"
+	StateRunning   RunState = "running"
+	StateSucceeded RunState = "succeeded"
+	StateFailed    RunState = "failed"
+	StateSkipped   RunState = "skipped" // a step that never ran because the run failed
+)
+
+// Run is the record of one execution of a workflow.
+type Run struct {
+	ID       string            `json:"id"`
+	Workflow string            `json:"workflow"`
+	Input    string            `json:"input,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	State  RunState  `json:"state"`
+	Result string    `json:"result,omitempty"` // the output step's result
+	Error  string    `json:"error,omitempty"`
+	Steps  []StepRun `json:"steps"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// StepRun is the status of one step in a run.
+type StepRun struct {
+	ID     string   `json:"id"`
+	Skill  string   `json:"skill"`
+	State  RunState `json:"state"`
+	TaskID string   `json:"task_id,omitempty"`
+	Agent  string   `json:"agent,omitempty"`
+	Result string   `json:"result,omitempty"` // payload of the task's last "result" event
+	Error  string   `json:"error,omitempty"`
+
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Executor runs a step's task on agentName, or on a provider of the skill if
+// agentName is empty, and returns the agent that handled it and the events
+// it produced. A task that ends with an "error", "timeout" or "cancelled"
+// event fails the step.
+type Executor func(ctx context.Context, req *pb.TaskRequest, agentName string, timeout time.Duration) (string, []*pb.TaskEvent, error)
+
+// Engine runs workflows and keeps the most recent runs in memory.
+type Engine struct {
+	exec Executor
+
+	mu    sync.RWMutex
+	runs  map[string]*Run
+	order []string // run IDs in creation order
+	limit int
+}
+
+// NewEngine creates an engine that executes steps with exec and retains up
+// to limit finished runs.
+func NewEngine(exec Executor, limit int) *Engine {
+	return &Engine{exec: exec, runs: make(map[string]*Run), limit: limit}
+}
+
+// Start begins a run of wf in the background and returns its initial record
+// and a channel closed when it finishes. Cancelling ctx cancels the steps
+// still running.
+func (e *Engine) Start(ctx context.Context, wf *Workflow, input string, metadata map[string]string) (Run, <-chan struct{}) {
+	run := &Run{
+		ID:        newID("run"),
+		Workflow:  wf.Name,
+		Input:     input,
+		Metadata:  metadata,
+		State:     StateRunning,
+		CreatedAt: time.Now().UTC(),
+	}
+	for _, s := range wf.Steps {
+		run.Steps = append(run.Steps, StepRun{ID: s.ID, Skill: s.Skill, State: StatePending})
+	}
+
+	e.mu.Lock()
+	e.runs[run.ID] = run
+	e.order = append(e.order, run.ID)
+	e.evict()
+	snap := run.snapshot()
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.execute(ctx, wf, run)
+	}()
+	return snap, done
+}
+
+// Get returns a copy of the run with the given ID.
+func (e *Engine) Get(id string) (Run, bool) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	r, ok := e.runs[id]
+	if !ok {
+		return Run{}, false
+	}
+	return r.snapshot(), true
+}
+
+// List returns the runs of the named workflow, newest first.
+func (e *Engine) List(workflow string) []Run {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	out := make([]Run, 0)
+	for i := len(e.order) - 1; i >= 0; i-- {
+		if r := e.runs[e.order[i]]; r.Workflow == workflow {
+			out = append(out, r.snapshot())
+		}
+	}
+	return out
+}
+
+// stepDone reports a finished step to the scheduler.
+type stepDone struct {
+	index int
+	err   error
+}
+
+// execute schedules the steps of run: each starts once all the steps it
+// needs have succeeded. The first failure cancels the steps still running
+// and skips the rest.
+func (e *Engine) execute(ctx context.Context, wf *Workflow, run *Run) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	index := make(map[string]int, len(wf.Steps))
+	waiting := make([]int, len(wf.Steps)) // unfinished needs per step
+	dependents := make([][]int, len(wf.Steps))
+	for i, s := range wf.Steps {
+		index[s.ID] = i
+		waiting[i] = len(s.Needs)
+	}
+	for i, s := range wf.Steps {
+		for _, dep := range s.Needs {
+			dependents[index[dep]] = append(dependents[index[dep]], i)
+		}
+	}
+
+	results := make(map[string]StepRun, len(wf.Steps))
+	doneCh := make(chan stepDone)
+	running := 0
+	var failure error
+
+	launch := func(i int) {
+		s := &wf.Steps[i]
+		input, err := wf.render(s, run.Input, run.Metadata, results)
+		if err != nil {
+			err = fmt.Errorf("step %q: input: %w", s.ID, err)
+			e.finishStep(run, i, "", nil, err)
+			failure = err
+			cancel()
+			return
+		}
+		req := &pb.TaskRequest{
+			TaskId:   newID("task"),
+			Skill:    s.Skill,
+			Input:    input,
+			Metadata: mergeMetadata(run.Metadata, s.Metadata),
+		}
+		e.startStep(run, i, req.TaskId)
+		running++
+		go func() {
+			agentName, events, err := e.exec(ctx, req, s.Agent, s.Timeout.Std())
+			doneCh <- stepDone{index: i, err: e.finishStep(run, i, agentName, events, err)}
+		}()
+	}
+
+	for i := range wf.Steps {
+		if waiting[i] == 0 && failure == nil {
+			launch(i)
+		}
+	}
+	for running > 0 {
+		d := <-doneCh
+		running--
+		if d.err != nil {
+			if failure == nil {
+				failure = fmt.Errorf("step %q: %w", wf.Steps[d.index].ID, d.err)
+				cancel()
+			}
+			continue
+		}
+		results[wf.Steps[d.index].ID] = e.step(run, d.index)
+		for _, j := range dependents[d.index] {
+			if waiting[j]--; waiting[j] == 0 && failure == nil {
+				launch(j)
+			}
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now().UTC()
+	run.FinishedAt = &now
+	for i := range run.Steps {
+		if run.Steps[i].State == StatePending {
+			run.Steps[i].State = StateSkipped
+		}
+	}
+	if failure != nil {
+		run.State = StateFailed
+		run.Error = failure.Error()
+		return
+	}
+	run.State = StateSucceeded
+	run.Result = run.Steps[index[wf.Output]].Result
+}
+
+func (e *Engine) startStep(run *Run, i int, taskID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now().UTC()
+	st := &run.Steps[i]
+	st.State = StateRunning
+	st.TaskID = taskID
+	st.StartedAt = &now
+}
+
+// finishStep records a step's outcome and returns the error that failed it,
+// if any.
+func (e *Engine) finishStep(run *Run, i int, agentName string, events []*pb.TaskEvent, err error) error {
+	if err == nil {
+		if n := len(events); n > 0 {
+			switch last := events[n-1]; last.Type {
+			case "error", "timeout", "cancelled":
+				err = fmt.Errorf("%s: %s", last.Type, last.Payload)
+			}
+		}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now().UTC()
+	st := &run.Steps[i]
+	st.Agent = agentName
+	st.FinishedAt = &now
+	if err != nil {
+		st.State = StateFailed
+		st.Error = err.Error()
+		return err
+	}
+	st.State = StateSucceeded
+	for _, ev := range events {
+		if ev.Type == "result" {
+			st.Result = ev.Payload
+		}
+	}
+	return nil
+}
+
+func (e *Engine) step(run *Run, i int) StepRun {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return run.Steps[i]
+}
+
+// evict drops the oldest finished runs while over the limit. Caller must
+// hold mu.
+func (e *Engine) evict() {
+	for i := 0; len(e.runs) > e.limit && i < len(e.order); {
+		id := e.order[i]
+		if e.runs[id].FinishedAt == nil {
+			i++
+			continue
+		}
+		delete(e.runs, id)
+		e.order = append(e.order[:i], e.order[i+1:]...)
+	}
+}
+
+// snapshot copies the run so callers can't race with updates.
+func (r *Run) snapshot() Run {
+	c := *r
+	c.Steps = append([]StepRun(nil), r.Steps...)
+	return c
+}
+
+// mergeMetadata returns the run's metadata overlaid with the step's.
+func mergeMetadata(run, step map[string]string) map[string]string {
+	if len(run) == 0 && len(step) == 0 {
+		return nil
+	}
+	out := maps.Clone(run)
+	if out == nil {
+		out = make(map[string]string, len(step))
+	}
+	maps.Copy(out, step)
+	return out
+}
+
+func newID(prefix string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%x", prefix, b)
+}
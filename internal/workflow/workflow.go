@@ -0,0 +1,220 @@
+// Package workflow runs declarative workflows: DAGs of skill steps whose
+// inputs are templated from the results of earlier steps.
+package workflow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"idra/internal/agent"
+)
+
+// ErrUnknownWorkflow is returned when no definition file exists for a name.
+var ErrUnknownWorkflow = errors.New("unknown workflow")
+
+// Workflow is a workflow definition, read from <name>.json in the workflows
+// directory.
+type Workflow struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Steps       []Step `json:"steps"`
+	Output      string `json:"output,omitempty"` // step whose result is the run's result; default the last step
+
+	inputs map[string]*template.Template // step ID → parsed Input
+}
+
+// Step runs one task. Steps run as soon as every step they need has
+// succeeded, so steps that need the same step fan out in parallel and a step
+// that needs several fans them back in.
+//
+// Input is a text/template rendered with .input and .metadata (the run's)
+// and .steps.<id>.result, .steps.<id>.agent and .steps.<id>.task_id for each
+// step listed in Needs. An empty Input is the run's input for a step without
+// needs and the result of the only needed step otherwise.
+type Step struct {
+	ID       string            `json:"id"`
+	Skill    string            `json:"skill"`
+	Agent    string            `json:"agent,omitempty"` // empty routes by skill
+	Input    string            `json:"input,omitempty"`
+	Needs    []string          `json:"needs,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"` // merged over the run's metadata
+	Timeout  agent.Duration    `json:"timeout,omitempty"`
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Load reads and validates the named workflow from dir.
+func Load(dir, name string) (*Workflow, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("%w %q", ErrUnknownWorkflow, name)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name+".json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w %q", ErrUnknownWorkflow, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read workflow %s: %w", name, err)
+	}
+
+	var wf Workflow
+	if err := json.Unmarshal(data, &wf); err != nil {
+		return nil, fmt.Errorf("parse workflow %s: %w", name, err)
+	}
+	if wf.Name != "" && wf.Name != name {
+		return nil, fmt.Errorf("workflow %s: name %q does not match the file name", name, wf.Name)
+	}
+	wf.Name = name
+	if err := wf.validate(); err != nil {
+		return nil, fmt.Errorf("invalid workflow %s: %w", name, err)
+	}
+	return &wf, nil
+}
+
+// LoadAll reads every valid workflow in dir, sorted by name. Invalid files
+// are logged and skipped.
+func LoadAll(dir string) ([]*Workflow, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Workflow, 0, len(paths))
+	for _, p := range paths {
+		wf, err := Load(dir, strings.TrimSuffix(filepath.Base(p), ".json"))
+		if err != nil {
+			slog.Warn("skipping workflow", "path", p, "error", err)
+			continue
+		}
+		out = append(out, wf)
+	}
+	return out, nil
+}
+
+// validate checks step IDs and needs, rejects cycles and parses the input
+// templates.
+func (wf *Workflow) validate() error {
+	if len(wf.Steps) == 0 {
+		return fmt.Errorf("at least one step is required")
+	}
+	steps := make(map[string]*Step, len(wf.Steps))
+	for i := range wf.Steps {
+		s := &wf.Steps[i]
+		if s.ID == "" {
+			return fmt.Errorf("steps[%d]: id is required", i)
+		}
+		if steps[s.ID] != nil {
+			return fmt.Errorf("duplicate step id %q", s.ID)
+		}
+		if s.Skill == "" {
+			return fmt.Errorf("step %q: skill is required", s.ID)
+		}
+		if s.Timeout < 0 {
+			return fmt.Errorf("step %q: timeout must not be negative", s.ID)
+		}
+		if _, err := agent.ParsePriority(s.Metadata["priority"]); err != nil {
+			return fmt.Errorf("step %q: %w", s.ID, err)
+		}
+		steps[s.ID] = s
+	}
+	for _, s := range wf.Steps {
+		for _, dep := range s.Needs {
+			if steps[dep] == nil {
+				return fmt.Errorf("step %q needs unknown step %q", s.ID, dep)
+			}
+		}
+	}
+	if _, err := wf.order(); err != nil {
+		return err
+	}
+	if wf.Output == "" {
+		wf.Output = wf.Steps[len(wf.Steps)-1].ID
+	} else if steps[wf.Output] == nil {
+		return fmt.Errorf("output names unknown step %q", wf.Output)
+	}
+
+	wf.inputs = make(map[string]*template.Template, len(wf.Steps))
+	for _, s := range wf.Steps {
+		src := s.Input
+		if src == "" {
+			src = "{{.input}}"
+			if len(s.Needs) == 1 {
+				src = fmt.Sprintf("{{(index .steps %q).result}}", s.Needs[0])
+			} else if len(s.Needs) > 1 {
+				return fmt.Errorf("step %q: input is required with more than one need", s.ID)
+			}
+		}
+		t, err := template.New(s.ID).Option("missingkey=error").Parse(src)
+		if err != nil {
+			return fmt.Errorf("step %q: input: %w", s.ID, err)
+		}
+		wf.inputs[s.ID] = t
+	}
+	return nil
+}
+
+// order returns the steps in a topological order, or an error naming a step
+// on a cycle.
+func (wf *Workflow) order() ([]*Step, error) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	byID := make(map[string]*Step, len(wf.Steps))
+	for i := range wf.Steps {
+		byID[wf.Steps[i].ID] = &wf.Steps[i]
+	}
+	mark := make(map[string]int, len(wf.Steps))
+	out := make([]*Step, 0, len(wf.Steps))
+	var visit func(s *Step) error
+	visit = func(s *Step) error {
+		switch mark[s.ID] {
+		case visiting:
+			return fmt.Errorf("step %q is part of a cycle", s.ID)
+		case done:
+			return nil
+		}
+		mark[s.ID] = visiting
+		for _, dep := range s.Needs {
+			if err := visit(byID[dep]); err != nil {
+				return err
+			}
+		}
+		mark[s.ID] = done
+		out = append(out, s)
+		return nil
+	}
+	for i := range wf.Steps {
+		if err := visit(&wf.Steps[i]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// render executes a step's input template. results holds the finished
+// steps; only the ones the step needs are visible to the template, and a
+// reference to any other step or to a missing metadata key is an error.
+func (wf *Workflow) render(s *Step, input string, metadata map[string]string, results map[string]StepRun) (string, error) {
+	steps := make(map[string]map[string]string, len(s.Needs))
+	for _, dep := range s.Needs {
+		r := results[dep]
+		steps[dep] = map[string]string{"result": r.Result, "agent": r.Agent, "task_id": r.TaskID}
+	}
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	var b strings.Builder
+	err := wf.inputs[s.ID].Execute(&b, map[string]any{
+		"input":    input,
+		"metadata": metadata,
+		"steps":    steps,
+	})
+	return b.String(), err
+}
@@ -0,0 +1,208 @@
+package workflow
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []Step
+		output  string
+		wantErr string // substring; empty = valid
+	}{
+		{"single step", []Step{{ID: "a", Skill: "summarize"}}, "", ""},
+		{"fan out and in", []Step{
+			{ID: "fetch", Skill: "fetch"},
+			{ID: "sum", Skill: "summarize", Needs: []string{"fetch"}},
+			{ID: "tr", Skill: "translate", Needs: []string{"fetch"}},
+			{ID: "join", Skill: "join", Needs: []string{"sum", "tr"}, Input: "{{(index .steps \"sum\").result}} {{(index .steps \"tr\").result}}"},
+		}, "", ""},
+		{"no steps", nil, "", "at least one step"},
+		{"missing id", []Step{{Skill: "summarize"}}, "", "id is required"},
+		{"duplicate id", []Step{{ID: "a", Skill: "x"}, {ID: "a", Skill: "y"}}, "", "duplicate step id"},
+		{"missing skill", []Step{{ID: "a"}}, "", "skill is required"},
+		{"negative timeout", []Step{{ID: "a", Skill: "x", Timeout: -1}}, "", "timeout must not be negative"},
+		{"unknown priority", []Step{{ID: "a", Skill: "x", Metadata: map[string]string{"priority": "urgent"}}}, "", "unknown priority"},
+		{"unknown need", []Step{{ID: "a", Skill: "x", Needs: []string{"b"}}}, "", "needs unknown step"},
+		{"self cycle", []Step{{ID: "a", Skill: "x", Needs: []string{"a"}}}, "", "cycle"},
+		{"cycle", []Step{
+			{ID: "a", Skill: "x", Needs: []string{"c"}},
+			{ID: "b", Skill: "x", Needs: []string{"a"}},
+			{ID: "c", Skill: "x", Needs: []string{"b"}},
+		}, "", "cycle"},
+		{"unknown output", []Step{{ID: "a", Skill: "x"}}, "z", "output names unknown step"},
+		{"fan in without input", []Step{
+			{ID: "a", Skill: "x"},
+			{ID: "b", Skill: "x"},
+			{ID: "c", Skill: "x", Needs: []string{"a", "b"}},
+		}, "", "input is required"},
+		{"bad template", []Step{{ID: "a", Skill: "x", Input: "{{.input"}}, "", "input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := &Workflow{Name: "test", Steps: tt.steps, Output: tt.output}
+			err := wf.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validate() error = %v", err)
+				}
+				if wf.Output != tt.steps[len(tt.steps)-1].ID && tt.output == "" {
+					t.Errorf("Output = %q, want the last step", wf.Output)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validate() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOrder(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []Step
+	}{
+		{"chain listed backwards", []Step{
+			{ID: "c", Skill: "x", Needs: []string{"b"}},
+			{ID: "b", Skill: "x", Needs: []string{"a"}},
+			{ID: "a", Skill: "x"},
+		}},
+		{"diamond", []Step{
+			{ID: "join", Skill: "x", Needs: []string{"left", "right"}},
+			{ID: "left", Skill: "x", Needs: []string{"root"}},
+			{ID: "right", Skill: "x", Needs: []string{"root"}},
+			{ID: "root", Skill: "x"},
+		}},
+		{"independent", []Step{{ID: "a", Skill: "x"}, {ID: "b", Skill: "x"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf := &Workflow{Steps: tt.steps}
+			order, err := wf.order()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(order) != len(tt.steps) {
+				t.Fatalf("order has %d steps, want %d", len(order), len(tt.steps))
+			}
+			pos := make(map[string]int, len(order))
+			for i, s := range order {
+				if _, dup := pos[s.ID]; dup {
+					t.Fatalf("step %q ordered twice", s.ID)
+				}
+				pos[s.ID] = i
+			}
+			for _, s := range tt.steps {
+				for _, dep := range s.Needs {
+					if pos[dep] > pos[s.ID] {
+						t.Errorf("step %q ordered before its need %q", s.ID, dep)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"digest.json":   `{"steps": [{"id": "a", "skill": "summarize"}]}`,
+		"renamed.json":  `{"name": "other", "steps": [{"id": "a", "skill": "summarize"}]}`,
+		"broken.json":   `{"steps": [`,
+		"cyclic.json":   `{"steps": [{"id": "a", "skill": "x", "needs": ["a"]}]}`,
+		"notes.txt":     `not a workflow`,
+		"..hidden.json": `{"steps": [{"id": "a", "skill": "summarize"}]}`,
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name        string
+		wantErr     bool
+		wantUnknown bool
+	}{
+		{"digest", false, false},
+		{"renamed", true, false},
+		{"broken", true, false},
+		{"cyclic", true, false},
+		{"missing", true, true},
+		{"../digest", true, true},
+		{"..hidden", true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wf, err := Load(dir, tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrUnknownWorkflow) != tt.wantUnknown {
+				t.Errorf("Load() error = %v, want ErrUnknownWorkflow: %v", err, tt.wantUnknown)
+			}
+			if err == nil && (wf.Name != tt.name || wf.Output != "a") {
+				t.Errorf("Load() = name %q, output %q", wf.Name, wf.Output)
+			}
+		})
+	}
+
+	all, err := LoadAll(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 || all[0].Name != "digest" {
+		t.Errorf("LoadAll() loaded %d workflows, want only digest", len(all))
+	}
+}
+
+func TestRender(t *testing.T) {
+	wf := &Workflow{Name: "test", Steps: []Step{
+		{ID: "fetch", Skill: "fetch"},
+		{ID: "sum", Skill: "summarize", Needs: []string{"fetch"}},
+		{ID: "tag", Skill: "tag", Input: "{{.metadata.lang}}: {{.input}}"},
+		{ID: "peek", Skill: "x", Needs: []string{"fetch"}, Input: `{{(index .steps "sum").result}}`},
+		{ID: "first", Skill: "x"},
+	}}
+	if err := wf.validate(); err != nil {
+		t.Fatal(err)
+	}
+	results := map[string]StepRun{
+		"fetch": {Result: "page text", Agent: "web", TaskID: "task-1"},
+		"sum":   {Result: "summary"},
+	}
+	tests := []struct {
+		step     string
+		metadata map[string]string
+		want     string
+		wantErr  bool
+	}{
+		{"sum", nil, "page text", false},
+		{"tag", map[string]string{"lang": "en"}, "en: run input", false},
+		{"tag", nil, "", true},  // missing metadata key
+		{"peek", nil, "", true}, // step not in needs
+		{"first", nil, "run input", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.step, func(t *testing.T) {
+			var step *Step
+			for i := range wf.Steps {
+				if wf.Steps[i].ID == tt.step {
+					step = &wf.Steps[i]
+				}
+			}
+			got, err := wf.render(step, "run input", tt.metadata, results)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("render() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,24 +5,70 @@
 
     let bearerToken = "";
     let tokenVisible = false;
+    let csrfToken = "";
 
+    // api calls the REST API with the session cookie. State-changing
+    // requests carry the session's CSRF token.
     function api(method, path, body) {
         const opts = {
             method: method,
             headers: { "Content-Type": "application/json" },
         };
-        if (bearerToken) {
-            opts.headers["Authorization"] = "Bearer " + bearerToken;
+        if (method !== "GET") {
+            opts.headers["X-CSRF-Token"] = csrfToken;
         }
         if (body !== undefined) {
             opts.body = JSON.stringify(body);
         }
         return fetch(path, opts).then((r) => {
+            if (r.status === 401 && path !== "/api/v1/session") showLogin();
             if (!r.ok) throw new Error(r.statusText);
-            return r.json();
+            return r.status === 204 ? null : r.json();
         });
     }
 
+    // --- Session ---
+
+    function showLogin() {
+        $("#app").hidden = true;
+        $("#login-section").hidden = false;
+    }
+
+    function startSession(s) {
+        csrfToken = s.csrf_token;
+        $("#session-name").textContent = s.name;
+        $("#login-section").hidden = true;
+        $("#app").hidden = false;
+        loadStatus();
+        loadConfig();
+        loadAgents();
+    }
+
+    $("#login-form").addEventListener("submit", function (e) {
+        e.preventDefault();
+        api("POST", "/api/v1/session", { token: $("#login-token").value })
+            .then((s) => {
+                $("#login-token").value = "";
+                $("#login-status").textContent = "";
+                startSession(s);
+            })
+            .catch(() => {
+                const el = $("#login-status");
+                el.textContent = "Invalid or expired token";
+                el.style.color = "#dc2626";
+            });
+    });
+
+    $("#logout").addEventListener("click", function () {
+        api("DELETE", "/api/v1/session")
+            .catch(() => {})
+            .then(() => {
+                csrfToken = "";
+                bearerToken = "";
+                showLogin();
+            });
+    });
+
     // --- Status ---
 
     function loadStatus() {
@@ -69,6 +115,7 @@
                     // Agent card
                     const card = document.createElement("div");
                     card.className = "agent-card";
+                    const state = a.enabled === false ? "disabled" : a.state;
                     const stateClass =
                         a.state === "running"
                             ? "badge-ok"
@@ -78,12 +125,21 @@
                     card.innerHTML =
                         '<div class="agent-header">' +
                         '  <span class="agent-name">' + esc(a.name) + "</span>" +
-                        '  <span class="badge ' + stateClass + '">' + esc(a.state) + "</span>" +
+                        '  <span class="badge ' + stateClass + '">' + esc(state) + "</span>" +
                         "</div>" +
                         '<div class="agent-details">' +
                         '  <span class="agent-skills">Skills: ' + esc((a.skills || []).join(", ")) + "</span>" +
                         (a.port ? '  <span class="agent-port">Port: ' + a.port + "</span>" : "") +
+                        (a.restarts ? '  <span class="agent-restarts">Restarts: ' + a.restarts + "</span>" : "") +
                         (a.error ? '  <span class="agent-error">' + esc(a.error) + "</span>" : "") +
+                        "</div>" +
+                        '<div class="agent-actions">' +
+                        ["start", "stop", "restart"]
+                            .map((act) =>
+                                '<button type="button" class="btn btn-small" data-action="' + act +
+                                '" data-agent="' + esc(a.name) + '">' +
+                                act.charAt(0).toUpperCase() + act.slice(1) + "</button>")
+                            .join("") +
                         "</div>";
                     list.appendChild(card);
 
@@ -102,6 +158,15 @@
             });
     }
 
+    $("#agents-list").addEventListener("click", function (e) {
+        const btn = e.target.closest("button[data-action]");
+        if (!btn) return;
+        btn.disabled = true;
+        api("POST", "/api/v1/agents/" + encodeURIComponent(btn.dataset.agent) + "/" + btn.dataset.action)
+            .catch(() => {})
+            .then(loadAgents);
+    });
+
     // --- Task execution ---
 
     $("#task-form").addEventListener("submit", function (e) {
@@ -116,23 +181,68 @@
         }
 
         showTaskStatus("Executing...", false);
-        $("#task-result").style.display = "none";
+        const output = $("#task-output");
+        output.textContent = "";
+        $("#task-result").style.display = "block";
 
-        api("POST", "/api/v1/agents/" + encodeURIComponent(agentName) + "/tasks", {
+        let failed = false;
+        streamTask("/api/v1/agents/" + encodeURIComponent(agentName) + "/tasks", {
             skill: skill,
             input: input,
+        }, (event, data) => {
+            if (event === "done") return;
+            if (event === "error") failed = true;
+            output.textContent += "[" + event + "] " + (data.payload || "") + "\n";
         })
-            .then((res) => {
-                showTaskStatus("Done", false);
-                const output = $("#task-output");
-                output.textContent = JSON.stringify(res, null, 2);
-                $("#task-result").style.display = "block";
+            .then(() => {
+                showTaskStatus(failed ? "Failed" : "Done", failed);
             })
             .catch((err) => {
                 showTaskStatus("Error: " + err.message, true);
             });
     });
 
+    // streamTask POSTs a task with Accept: text/event-stream and calls
+    // onEvent(name, data) for every Server-Sent Event as it arrives.
+    function streamTask(path, body, onEvent) {
+        const headers = {
+            "Content-Type": "application/json",
+            Accept: "text/event-stream",
+            "X-CSRF-Token": csrfToken,
+        };
+        return fetch(path, { method: "POST", headers: headers, body: JSON.stringify(body) }).then((r) => {
+            if (!r.ok) {
+                return r.json().then(
+                    (e) => { throw new Error(e.error || r.statusText); },
+                    () => { throw new Error(r.statusText); }
+                );
+            }
+            const reader = r.body.getReader();
+            const decoder = new TextDecoder();
+            let buf = "";
+            function pump() {
+                return reader.read().then((chunk) => {
+                    if (chunk.done) return;
+                    buf += decoder.decode(chunk.value, { stream: true });
+                    let idx;
+                    while ((idx = buf.indexOf("\n\n")) >= 0) {
+                        const block = buf.slice(0, idx);
+                        buf = buf.slice(idx + 2);
+                        let name = "message";
+                        let data = "";
+                        block.split("\n").forEach((line) => {
+                            if (line.startsWith("event: ")) name = line.slice(7);
+                            else if (line.startsWith("data: ")) data += line.slice(6);
+                        });
+                        onEvent(name, data ? JSON.parse(data) : {});
+                    }
+                    return pump();
+                });
+            }
+            return pump();
+        });
+    }
+
     function showTaskStatus(msg, isError) {
         const el = $("#task-status");
         el.textContent = msg;
@@ -160,7 +270,7 @@
             port: parseInt($("#cfg-port").value, 10),
             auto_open_browser: $("#cfg-auto-open").checked,
         };
-        api("PUT", "/api/v1/config", payload)
+        api("PATCH", "/api/v1/config", payload)
             .then(() => {
                 const el = $("#save-status");
                 el.textContent = "Saved";
@@ -202,9 +312,10 @@
 
     // --- Init ---
 
-    loadStatus();
-    loadConfig();
-    loadAgents();
-    setInterval(loadStatus, 10000);
-    setInterval(loadAgents, 10000);
+    api("GET", "/api/v1/session").then(startSession, showLogin);
+    setInterval(() => {
+        if ($("#app").hidden) return;
+        loadStatus();
+        loadAgents();
+    }, 10000);
 })();